@@ -0,0 +1,87 @@
+package fetch
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"time"
+)
+
+// MaxBodySize is the maximum number of bytes read from a response body
+var MaxBodySize int64 = 20 << 20
+
+// ErrTooLarge is returned when reading a response body that exceeds MaxBodySize
+var ErrTooLarge = errors.New("response body too large")
+
+// Client is the HTTP client used to download remote content.
+// It refuses to connect to forbidden IP addresses (see Allow and Deny).
+var Client = &http.Client{
+	Transport: &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+			Control:   checkAddress,
+		}).DialContext,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+	},
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		if len(via) > 10 {
+			return fmt.Errorf("too many redirects")
+		}
+		return nil
+	},
+}
+
+// Get requests the given URL using the shared Client.
+// Reading more than MaxBodySize bytes from the response body results in ErrTooLarge.
+func Get(ctx context.Context, url, accept string) (*http.Response, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(accept) > 0 {
+		req.Header.Set("accept", accept)
+	}
+
+	resp, err := Client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.ContentLength > MaxBodySize {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%w: %d bytes (%s)", ErrTooLarge, resp.ContentLength, url)
+	}
+
+	resp.Body = &limitedBody{ReadCloser: resp.Body, remaining: MaxBodySize}
+	return resp, nil
+}
+
+type limitedBody struct {
+	io.ReadCloser
+	remaining int64
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.remaining <= 0 {
+		// make sure there is nothing left before reporting an error
+		var buf [1]byte
+		if n, _ := b.ReadCloser.Read(buf[:]); n > 0 {
+			return 0, ErrTooLarge
+		}
+		return 0, io.EOF
+	}
+
+	if int64(len(p)) > b.remaining {
+		p = p[:b.remaining]
+	}
+	n, err := b.ReadCloser.Read(p)
+	b.remaining -= int64(n)
+	return n, err
+}
@@ -0,0 +1,134 @@
+package fetch
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// ErrForbiddenAddress is returned when a request would connect to a blocked IP address
+var ErrForbiddenAddress = errors.New("forbidden address")
+
+var (
+	guardMu   sync.RWMutex
+	allowList []*net.IPNet
+	denyList  = mustParseCIDRs(
+		"0.0.0.0/8",      // "this" network
+		"10.0.0.0/8",     // private
+		"100.64.0.0/10",  // carrier-grade NAT
+		"127.0.0.0/8",    // loopback
+		"169.254.0.0/16", // link-local (incl. cloud metadata)
+		"172.16.0.0/12",  // private
+		"192.0.0.0/24",   // IETF protocol assignments
+		"192.168.0.0/16", // private
+		"198.18.0.0/15",  // benchmarking
+		"224.0.0.0/4",    // multicast
+		"240.0.0.0/4",    // reserved, broadcast
+		"::/128",         // unspecified
+		"::1/128",        // loopback
+		"64:ff9b::/96",   // IPv4/IPv6 translation
+		"fc00::/7",       // unique local
+		"fe80::/10",      // link-local
+		"ff00::/8",       // multicast
+	)
+)
+
+// Allow adds comma separated CIDRs that are reachable even if they are denied otherwise
+func Allow(cidrs string) error {
+	nets, err := parseCIDRList(cidrs)
+	if err != nil {
+		return err
+	}
+
+	guardMu.Lock()
+	defer guardMu.Unlock()
+	allowList = append(allowList, nets...)
+	return nil
+}
+
+// Deny adds comma separated CIDRs to the list of forbidden destinations
+func Deny(cidrs string) error {
+	nets, err := parseCIDRList(cidrs)
+	if err != nil {
+		return err
+	}
+
+	guardMu.Lock()
+	defer guardMu.Unlock()
+	denyList = append(denyList, nets...)
+	return nil
+}
+
+// IsAllowed returns whether connecting to the given IP address is permitted
+func IsAllowed(ip net.IP) bool {
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+
+	guardMu.RLock()
+	defer guardMu.RUnlock()
+
+	for _, n := range allowList {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	for _, n := range denyList {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// checkAddress is called by the dialer after DNS resolution, right before connecting,
+// so redirects and DNS changes can't be used to reach a forbidden address
+func checkAddress(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return fmt.Errorf("%w: %s", ErrForbiddenAddress, host)
+	}
+	if !IsAllowed(ip) {
+		return fmt.Errorf("%w: %s", ErrForbiddenAddress, ip)
+	}
+	return nil
+}
+
+func parseCIDRList(cidrs string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, cidr := range strings.Split(cidrs, ",") {
+		cidr = strings.TrimSpace(cidr)
+		if len(cidr) == 0 {
+			continue
+		}
+		if !strings.Contains(cidr, "/") {
+			if strings.Contains(cidr, ":") {
+				cidr += "/128"
+			} else {
+				cidr += "/32"
+			}
+		}
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	nets, err := parseCIDRList(strings.Join(cidrs, ","))
+	if err != nil {
+		panic(err)
+	}
+	return nets
+}
@@ -0,0 +1,89 @@
+package fetch
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// sniffLen is the number of bytes read ahead from a response body to determine the type of the content
+const sniffLen = 4096
+
+// genericTypes are sent by misconfigured servers instead of the actual media type
+var genericTypes = map[string]bool{
+	"":                         true,
+	"application/octet-stream": true,
+	"binary/octet-stream":      true,
+	"application/unknown":      true,
+	"application/x-unknown":    true,
+	"text/plain":               true,
+}
+
+type sniffedBody struct {
+	io.Reader
+	io.Closer
+}
+
+// Sniff returns the media type of the response body (without parameters) based on the Content-Type header
+// and the beginning of the content. The body stays readable from the start.
+func Sniff(resp *http.Response) string {
+	br := bufio.NewReaderSize(resp.Body, sniffLen)
+	head, _ := br.Peek(sniffLen)
+	resp.Body = &sniffedBody{Reader: br, Closer: resp.Body}
+	return ContentType(resp.Header.Get("Content-Type"), head)
+}
+
+// ContentType reconciles the Content-Type header with the type detected from the first bytes of the content.
+// Magic numbers of images take precedence over the header, as do HTML pages served as images (like error pages).
+// Otherwise the detected type is only used if the header is missing or generic, like application/octet-stream.
+func ContentType(header string, head []byte) string {
+	declared, _, _ := mime.ParseMediaType(header)
+	declared = strings.ToLower(declared)
+	detected := detectContentType(head)
+
+	switch {
+	case strings.HasPrefix(detected, "image/"):
+		return detected
+	case IsHTML(detected) && strings.HasPrefix(declared, "image/"):
+		return detected
+	case genericTypes[declared]:
+		return detected
+	default:
+		return declared
+	}
+}
+
+// IsHTML tells if the media type is an HTML or XHTML page
+func IsHTML(mediaType string) bool {
+	return mediaType == "text/html" || mediaType == "application/xhtml+xml"
+}
+
+// detectContentType extends http.DetectContentType with TIFF, SVG and XHTML
+func detectContentType(head []byte) string {
+	switch {
+	case bytes.HasPrefix(head, []byte("II\x2A\x00")), bytes.HasPrefix(head, []byte("MM\x00\x2A")):
+		return "image/tiff"
+	}
+
+	detected, _, _ := mime.ParseMediaType(http.DetectContentType(head))
+	if detected != "text/xml" && detected != "text/plain" {
+		return detected
+	}
+
+	text := bytes.TrimSpace(bytes.TrimPrefix(head, []byte("\xef\xbb\xbf")))
+	if len(text) == 0 || text[0] != '<' {
+		return detected
+	}
+	lower := bytes.ToLower(text)
+	switch {
+	case bytes.Contains(lower, []byte("<html")):
+		return "application/xhtml+xml"
+	case bytes.Contains(text, []byte("<svg")):
+		return "image/svg+xml"
+	default:
+		return detected
+	}
+}
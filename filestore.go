@@ -0,0 +1,197 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/razzie/mediaserver/media"
+)
+
+// fileSweepInterval is how often expired files are removed and the limits of a FileStore are enforced
+const fileSweepInterval = time.Minute
+
+// FileStore is a Store that keeps Media as JSON files in a directory.
+// The modification time of each file is set to its expiration time, so expired files can be found without reading them.
+type FileStore struct {
+	ExpirationTime time.Duration
+	MaxItems       int
+	MaxSize        int64
+	dir            string
+}
+
+type fileItem struct {
+	Expires time.Time    `json:"expires"`
+	Media   *media.Media `json:"media"`
+}
+
+// NewFileStore returns a new FileStore that uses the given directory.
+// The max-items and max-size (bytes) query parameters limit the number of files and their total size.
+// Expired files are removed and the limits are enforced periodically, evicting the files that expire the soonest.
+func NewFileStore(dir string, query url.Values, expiration time.Duration) (*FileStore, error) {
+	store := &FileStore{
+		ExpirationTime: expiration,
+		MaxItems:       10000,
+		MaxSize:        1 << 30,
+		dir:            dir,
+	}
+
+	if maxItems := query.Get("max-items"); len(maxItems) > 0 {
+		n, err := strconv.Atoi(maxItems)
+		if err != nil {
+			return nil, err
+		}
+		store.MaxItems = n
+	}
+
+	if maxSize := query.Get("max-size"); len(maxSize) > 0 {
+		n, err := strconv.ParseInt(maxSize, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		store.MaxSize = n
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	go store.sweepPeriodically()
+	return store, nil
+}
+
+// GetMedia returns a saved Media
+func (s *FileStore) GetMedia(key string) (*media.Media, error) {
+	data, err := ioutil.ReadFile(s.filename(key))
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var item fileItem
+	if err := json.Unmarshal(data, &item); err != nil {
+		return nil, err
+	}
+
+	if item.Media == nil || time.Now().After(item.Expires) {
+		s.Delete(key)
+		return nil, ErrNotFound
+	}
+
+	return item.Media, nil
+}
+
+// SetMedia saves a Media
+func (s *FileStore) SetMedia(key string, m *media.Media) error {
+	expires := time.Now().Add(expirationOf(m, s.ExpirationTime))
+	data, err := json.Marshal(&fileItem{
+		Expires: expires,
+		Media:   m,
+	})
+	if err != nil {
+		return err
+	}
+
+	// write to a temporary file first so readers never see a partial file
+	tmp, err := ioutil.TempFile(s.dir, ".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chtimes(tmp.Name(), expires, expires); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), s.filename(key))
+}
+
+// Delete removes a saved Media
+func (s *FileStore) Delete(key string) error {
+	err := os.Remove(s.filename(key))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// Stats returns the number of files and their total size
+func (s *FileStore) Stats() (*StoreStats, error) {
+	stats := &StoreStats{Backend: "file"}
+
+	files, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), ".json") {
+			continue
+		}
+		stats.Items++
+		stats.Size += file.Size()
+	}
+
+	return stats, nil
+}
+
+func (s *FileStore) sweepPeriodically() {
+	for {
+		s.sweep()
+		time.Sleep(fileSweepInterval)
+	}
+}
+
+// sweep removes the expired files, then the ones that expire the soonest while the store is over its limits
+func (s *FileStore) sweep() {
+	files, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return
+	}
+
+	now := time.Now()
+	var items []os.FileInfo
+	var size int64
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), ".json") {
+			continue
+		}
+		if now.After(file.ModTime()) {
+			os.Remove(filepath.Join(s.dir, file.Name()))
+			continue
+		}
+		items = append(items, file)
+		size += file.Size()
+	}
+
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].ModTime().Before(items[j].ModTime())
+	})
+	for len(items) > 1 && (len(items) > s.MaxItems || size > s.MaxSize) {
+		os.Remove(filepath.Join(s.dir, items[0].Name()))
+		size -= items[0].Size()
+		items = items[1:]
+	}
+}
+
+func (s *FileStore) filename(key string) string {
+	hash := sha1.Sum([]byte(key))
+	return filepath.Join(s.dir, hex.EncodeToString(hash[:])+".json")
+}
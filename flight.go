@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/razzie/mediaserver/media"
+)
+
+// flightGroup coalesces concurrent fetches of the same key into a single call
+type flightGroup struct {
+	mtx   sync.Mutex
+	calls map[string]*flightCall
+}
+
+type flightCall struct {
+	done  chan struct{}
+	media *media.Media
+	err   error
+}
+
+// Do calls fn unless there is already a call in flight with the same key,
+// in which case it waits for that call to finish and returns its result.
+// Waiting can be abandoned by cancelling ctx, but that doesn't affect fn.
+func (g *flightGroup) Do(ctx context.Context, key string, fn func() (*media.Media, error)) (*media.Media, error) {
+	g.mtx.Lock()
+	if g.calls == nil {
+		g.calls = make(map[string]*flightCall)
+	}
+	call, ok := g.calls[key]
+	if !ok {
+		call = &flightCall{done: make(chan struct{})}
+		g.calls[key] = call
+		go g.run(key, call, fn)
+	}
+	g.mtx.Unlock()
+
+	select {
+	case <-call.done:
+		return call.media, call.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (g *flightGroup) run(key string, call *flightCall, fn func() (*media.Media, error)) {
+	defer func() {
+		if r := recover(); r != nil {
+			call.media, call.err = nil, fmt.Errorf("panic while fetching %s: %v", key, r)
+		}
+		g.mtx.Lock()
+		delete(g.calls, key)
+		g.mtx.Unlock()
+		close(call.done)
+	}()
+
+	call.media, call.err = fn()
+}
@@ -6,6 +6,7 @@ require (
 	github.com/go-redis/redis/v7 v7.2.0
 	github.com/golang/freetype v0.0.0-20170609003504-e2365dfdc4a0
 	github.com/nfnt/resize v0.0.0-20180221191011-83c6a9932646
-	golang.org/x/image v0.0.0-20200119044424-58c23975cae1
-	golang.org/x/net v0.0.0-20200202094626-16171245cfb2
+	golang.org/x/image v0.18.0
+	golang.org/x/net v0.25.0
+	golang.org/x/text v0.16.0
 )
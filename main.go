@@ -4,34 +4,94 @@ import (
 	"flag"
 	"log"
 	"net/http"
+	"runtime"
 	"strconv"
 	"time"
 
+	"github.com/razzie/mediaserver/fetch"
+	"github.com/razzie/mediaserver/media"
 	"github.com/razzie/mediaserver/thumb"
 )
 
 // Command-line args
 var (
 	RedisConnStr  string
+	CacheURL      string
 	Port          int
 	CacheDuration time.Duration
+	AllowCIDRs    string
+	DenyCIDRs     string
+	FetchTimeout  time.Duration
+	Lock          bool
+	Workers       int
+	QueueSize     int
+	LabelFonts    string
+	ThumbOptions  = thumb.DefaultOptions()
 )
 
 func main() {
-	flag.StringVar(&RedisConnStr, "redis", "redis://localhost:6379", "Redis connection string")
+	flag.StringVar(&RedisConnStr, "redis", "redis://localhost:6379", "Redis connection string (used if -cache is not set)")
+	flag.StringVar(&CacheURL, "cache", "", "Cache backend URL: memory://, file:///path/to/dir or redis://host:port")
 	flag.IntVar(&Port, "port", 8080, "HTTP port to listen on")
-	flag.IntVar(&thumb.Quality, "thumb-quality", 90, "Quality of the thumbnail images (1-100)")
-	flag.UintVar(&thumb.Size, "thumb-size", 256, "Maximum width or height of thumbnail images")
+	flag.IntVar(&ThumbOptions.Quality, "thumb-quality", ThumbOptions.Quality, "Default quality of the thumbnail images (1-100)")
+	flag.UintVar(&ThumbOptions.Width, "thumb-size", ThumbOptions.Width, "Default maximum width or height of thumbnail images")
+	flag.StringVar(&ThumbOptions.Format, "thumb-format", ThumbOptions.Format, "Default format of thumbnail images (auto, jpeg, png, gif or webp)")
+	flag.StringVar(&ThumbOptions.Filter, "thumb-filter", ThumbOptions.Filter, "Default resampling filter (nearest, bilinear, bicubic, mitchell, lanczos2 or lanczos3)")
+	flag.StringVar(&ThumbOptions.Background, "thumb-bg", "", "Default background color of transparent thumbnail images (hex, keeps transparency if empty)")
+	flag.StringVar(&ThumbOptions.Crop, "thumb-crop", "", "Default crop mode of thumbnails (center, top or smart), fits the image in the box if empty")
+	flag.StringVar(&ThumbOptions.LabelSource, "label", ThumbOptions.LabelSource, "Default label of website thumbnails (title, site, host or none)")
+	flag.StringVar(&ThumbOptions.LabelPosition, "label-pos", ThumbOptions.LabelPosition, "Default label position (top or bottom, left, center or right, e.g. bottom-left)")
+	flag.IntVar(&ThumbOptions.LabelSize, "label-size", ThumbOptions.LabelSize, "Default label font size in percent of the thumbnail size")
+	flag.StringVar(&ThumbOptions.LabelColor, "label-color", ThumbOptions.LabelColor, "Default label text color (hex)")
+	flag.StringVar(&ThumbOptions.LabelBackground, "label-bg", ThumbOptions.LabelBackground, "Default color of the bar behind labels (hex)")
+	flag.StringVar(&LabelFonts, "label-fonts", "", "Comma separated list of TTF or OTF font files labels are drawn with (in order of preference)")
+	flag.IntVar(&thumb.MaxLabelLines, "label-lines", thumb.MaxLabelLines, "Number of lines long labels are wrapped to before they get truncated")
+	flag.UintVar(&thumb.MaxSize, "thumb-max-size", thumb.MaxSize, "Maximum width or height a request can ask for")
 	flag.DurationVar(&CacheDuration, "cache-duration", time.Hour*24, "Thumbnail cache expiration time")
+	flag.StringVar(&AllowCIDRs, "allow-cidr", "", "Comma separated list of CIDRs that can be fetched even if they are private")
+	flag.StringVar(&DenyCIDRs, "deny-cidr", "", "Comma separated list of CIDRs that can't be fetched (besides private addresses)")
+	flag.DurationVar(&FetchTimeout, "fetch-timeout", time.Minute, "Maximum time spent on fetching a single media")
+	flag.BoolVar(&Lock, "distributed-lock", false, "Coalesce fetches between server instances sharing a Redis cache")
+	flag.IntVar(&Workers, "thumb-workers", runtime.NumCPU(), "Number of images decoded and resized at the same time")
+	flag.IntVar(&QueueSize, "thumb-queue", 64, "Number of images that can wait for processing before responding with 503")
+	flag.Int64Var(&fetch.MaxBodySize, "max-download-size", fetch.MaxBodySize, "Maximum size of downloaded pages and images in bytes")
+	flag.IntVar(&thumb.MaxPixels, "max-pixels", thumb.MaxPixels, "Maximum number of pixels (width*height) of processed images")
+	flag.IntVar(&thumb.MaxFrames, "max-frames", thumb.MaxFrames, "Maximum number of frames of animated thumbnails")
+	flag.IntVar(&media.MaxProbes, "image-probes", media.MaxProbes, "Number of website images without size hints whose dimensions are probed before choosing one")
+	flag.IntVar(&media.ParallelImages, "image-parallel", media.ParallelImages, "Number of website images fetched at the same time")
+	flag.DurationVar(&media.ImageDeadline, "image-deadline", media.ImageDeadline, "Maximum time spent on ranking and fetching the images of a website before falling back to a text card")
+	flag.BoolVar(&media.Debug, "debug", false, "Log the timing of fetching each website image")
+	flag.IntVar(&thumb.MaxAnimationSize, "max-animation-size", thumb.MaxAnimationSize, "Maximum size of animated thumbnails in bytes")
 	flag.Parse()
 
-	db, err := NewDB(RedisConnStr)
-	if err != nil {
-		log.Fatalln("failed to connect to database:", err)
+	ThumbOptions.Height = ThumbOptions.Width
+	if err := ThumbOptions.Validate(); err != nil {
+		log.Fatalln("invalid thumbnail options:", err)
+	}
+	thumb.DefaultPool = thumb.NewPool(Workers, QueueSize)
+
+	if err := thumb.LoadFonts(LabelFonts); err != nil {
+		log.Fatalln("invalid -label-fonts:", err)
+	}
+
+	if err := fetch.Allow(AllowCIDRs); err != nil {
+		log.Fatalln("invalid -allow-cidr:", err)
+	}
+	if err := fetch.Deny(DenyCIDRs); err != nil {
+		log.Fatalln("invalid -deny-cidr:", err)
+	}
+
+	if len(CacheURL) == 0 {
+		CacheURL = RedisConnStr
 	}
 
-	db.ExpirationTime = CacheDuration
+	store, err := NewStore(CacheURL, CacheDuration)
+	if err != nil {
+		log.Fatalln("failed to initialize cache:", err)
+	}
 
-	server := NewServer(db)
+	server := NewServer(store, ThumbOptions)
+	server.FetchTimeout = FetchTimeout
+	server.DistributedLock = Lock
 	log.Fatal(http.ListenAndServe(":"+strconv.Itoa(Port), server))
 }
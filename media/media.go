@@ -2,70 +2,157 @@ package media
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"hash/crc32"
 	"net/http"
+	neturl "net/url"
 	"strconv"
 	"strings"
 
+	"github.com/razzie/mediaserver/fetch"
 	"github.com/razzie/mediaserver/siteinfo"
 	"github.com/razzie/mediaserver/thumb"
 )
 
+// ErrNoIcon is returned when none of the icons of a website could be loaded
+var ErrNoIcon = errors.New("no icon available")
+
 // Media contains basic details about a website and a thumbnail
 type Media struct {
 	SiteInfo  *siteinfo.SiteInfo `json:"siteinfo"`
 	Thumbnail *thumb.Thumbnail   `json:"thumbnail"`
+	Image     *Candidate         `json:"image,omitempty"` // the image of the website the thumbnail was made of
 }
 
-// GetFromURL tries to get media data from an URL
-func GetFromURL(ctx context.Context, url string) (*Media, error) {
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		return nil, err
-	}
+// Metadata is the JSON friendly representation of Media without the thumbnail data
+type Metadata struct {
+	SiteInfo  *siteinfo.SiteInfo `json:"siteinfo,omitempty"`
+	Thumbnail *thumb.Info        `json:"thumbnail,omitempty"`
+	Image     *Candidate         `json:"image,omitempty"`
+}
 
-	cl := http.Client{}
-	cl.CheckRedirect = func(req *http.Request, via []*http.Request) error {
-		if len(via) > 10 {
-			return fmt.Errorf("too many redirects")
-		}
-		url = req.URL.String()
-		return nil
+// Metadata returns the metadata of the Media, imageURL being the address of the thumbnail
+func (m Media) Metadata(imageURL string) *Metadata {
+	meta := &Metadata{SiteInfo: m.SiteInfo, Image: m.Image}
+	if m.Thumbnail != nil {
+		meta.Thumbnail = m.Thumbnail.Info(imageURL)
 	}
+	return meta
+}
+
+func (meta Metadata) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(meta)
+}
 
-	resp, err := cl.Do(req.WithContext(ctx))
+// GetFromURL tries to get media data from an URL
+func GetFromURL(ctx context.Context, url string, opts thumb.Options) (*Media, error) {
+	resp, err := fetch.Get(ctx, url, "")
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
 
+	url = resp.Request.URL.String() // in case of redirects
+
 	m := &Media{}
 
-	if !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/html") {
-		m.Thumbnail, err = thumb.Get(resp.Body, "")
+	if !fetch.IsHTML(fetch.Sniff(resp)) {
+		m.Thumbnail, err = thumb.Get(resp.Body, "", opts)
 		return m, err
 	}
 
-	m.SiteInfo, err = siteinfo.Get(resp.Body)
+	m.SiteInfo, err = siteinfo.GetWithContentType(resp.Body, resp.Header.Get("Content-Type"))
 	if err != nil {
 		return m, err
 	}
 
-	if len(m.SiteInfo.Images) == 0 {
-		return m, fmt.Errorf("no thumbnail available")
+	m.SiteInfo.ResolveImageURLs(url)
+	m.SiteInfo.SelectImageSize(int(maxSize(opts)))
+	m.SiteInfo.ResolveIconURLs(url)
+	label := labelOf(m.SiteInfo, url, opts.LabelSource)
+
+	imageCtx, cancel := context.WithTimeout(ctx, ImageDeadline)
+	defer cancel()
+	m.Thumbnail, m.Image, err = fetchBestImage(imageCtx, RankImages(imageCtx, m.SiteInfo, opts), label, opts)
+	if m.Thumbnail != nil || err == thumb.ErrBusy || ctx.Err() != nil {
+		return m, err
 	}
 
-	m.SiteInfo.ResolveImageURLs(url)
+	// no usable image, so a card is made of the text instead
+	m.Thumbnail, err = thumb.GetCard(thumb.Card{
+		Title:       m.SiteInfo.Title,
+		Description: m.SiteInfo.Description,
+		Host:        hostname(url),
+	}, opts)
+	return m, err
+}
 
-	for _, img := range m.SiteInfo.Images {
-		m.Thumbnail, err = thumb.GetFromURL(ctx, img, m.SiteInfo.Title)
-		if err == nil {
-			return m, nil
+// GetIconFromURL returns the icon of the website at the URL that suits the requested thumbnail size the best
+func GetIconFromURL(ctx context.Context, url string, opts thumb.Options) (*Media, error) {
+	resp, err := fetch.Get(ctx, url, "text/html")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	url = resp.Request.URL.String() // in case of redirects
+
+	m := &Media{SiteInfo: &siteinfo.SiteInfo{}}
+	if fetch.IsHTML(fetch.Sniff(resp)) {
+		m.SiteInfo, err = siteinfo.GetWithContentType(resp.Body, resp.Header.Get("Content-Type"))
+		if err != nil {
+			return m, err
 		}
 	}
 
-	return m, err
+	m.SiteInfo.ResolveIconURLs(url)
+	m.SiteInfo.FetchManifestIcons(ctx)
+
+	opts.LabelSource = "none"
+	for _, icon := range m.SiteInfo.IconsBySize(int(maxSize(opts))) {
+		m.Thumbnail, err = thumb.GetFromURL(ctx, icon.URL, "", opts)
+		if err == nil || err == thumb.ErrBusy || ctx.Err() != nil {
+			return m, err
+		}
+	}
+
+	return m, ErrNoIcon
+}
+
+// maxSize returns the larger dimension of the requested thumbnail
+func maxSize(opts thumb.Options) uint {
+	if opts.Height > opts.Width {
+		return opts.Height
+	}
+	return opts.Width
+}
+
+// labelOf returns the text drawn on the thumbnail of a website based on the label source
+func labelOf(s *siteinfo.SiteInfo, pageURL, source string) string {
+	switch source {
+	case "title":
+		return s.Title
+	case "site":
+		if len(s.SiteName) > 0 {
+			return s.SiteName
+		}
+		return hostname(pageURL)
+	case "host":
+		return hostname(pageURL)
+	default:
+		return ""
+	}
+}
+
+func hostname(pageURL string) string {
+	u, err := neturl.Parse(pageURL)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimPrefix(u.Hostname(), "www.")
 }
 
 func (m Media) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -0,0 +1,110 @@
+package media
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/razzie/mediaserver/thumb"
+)
+
+// ParallelImages is the number of website images fetched at the same time
+var ParallelImages = 3
+
+// ImageDeadline is the maximum time spent on ranking and fetching the images of a website
+var ImageDeadline = 15 * time.Second
+
+// Debug enables logging the timing of each fetched website image
+var Debug = false
+
+type imageResult struct {
+	index     int
+	thumbnail *thumb.Thumbnail
+	err       error
+	elapsed   time.Duration
+}
+
+// fetchBestImage fetches ParallelImages candidates at the same time until the context is done,
+// starting the next one whenever one finishes, and returns the thumbnail of the highest ranked one that succeeds.
+// Lower ranked candidates are cancelled once the winner is known.
+// If the context is done first, the highest ranked candidate that has succeeded so far wins.
+func fetchBestImage(ctx context.Context, candidates []Candidate, label string, opts thumb.Options) (*thumb.Thumbnail, *Candidate, error) {
+	if len(candidates) == 0 {
+		return nil, nil, nil
+	}
+
+	results := make(chan imageResult, len(candidates))
+	cancels := make([]context.CancelFunc, len(candidates))
+	started := make([]time.Time, len(candidates))
+	finished := make([]*imageResult, len(candidates))
+	next := 0
+	start := func() {
+		i := next
+		next++
+		var candidateCtx context.Context
+		candidateCtx, cancels[i] = context.WithCancel(ctx)
+		started[i] = time.Now()
+		go func() {
+			t, err := thumb.GetFromURL(candidateCtx, candidates[i].URL, label, opts)
+			results <- imageResult{index: i, thumbnail: t, err: err, elapsed: time.Since(started[i])}
+		}()
+	}
+	defer func() {
+		for i := 0; i < next; i++ {
+			cancels[i]()
+			if Debug && finished[i] == nil {
+				log.Printf("image #%d %s: cancelled after %v", candidates[i].Rank, candidates[i].URL, time.Since(started[i]))
+			}
+		}
+	}()
+
+	for next < len(candidates) && next < ParallelImages {
+		start()
+	}
+
+	best := 0 // the highest ranked candidate that may still succeed
+	var err error
+	for running := next; running > 0; running-- {
+		var r imageResult
+		select {
+		case r = <-results:
+		case <-ctx.Done():
+			// the pool doesn't stop processing the images that are already downloaded, so they aren't waited for
+			for i := best; i < next; i++ {
+				if finished[i] != nil && finished[i].err == nil {
+					return finished[i].thumbnail, &candidates[i], nil
+				}
+			}
+			return nil, nil, ctx.Err()
+		}
+		finished[r.index] = &r
+		if Debug {
+			log.Printf("image #%d %s: %v after %v", candidates[r.index].Rank, candidates[r.index].URL, errorOrOK(r.err), r.elapsed)
+		}
+		if r.err == thumb.ErrBusy {
+			return nil, nil, r.err
+		}
+
+		for best < next && finished[best] != nil {
+			if finished[best].err == nil {
+				return finished[best].thumbnail, &candidates[best], nil
+			}
+			err = finished[best].err
+			best++
+		}
+
+		if next < len(candidates) && ctx.Err() == nil {
+			start()
+			running++
+		}
+	}
+
+	return nil, nil, err
+}
+
+func errorOrOK(err error) interface{} {
+	if err != nil {
+		return err
+	}
+	return "ok"
+}
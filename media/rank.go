@@ -0,0 +1,217 @@
+package media
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"io"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/razzie/mediaserver/fetch"
+	"github.com/razzie/mediaserver/siteinfo"
+	"github.com/razzie/mediaserver/thumb"
+)
+
+// MaxProbes is the number of images without size hints whose dimensions are read from a partial download
+var MaxProbes = 8
+
+// probeSize is the number of bytes downloaded to read the dimensions of an image
+const probeSize = 64 << 10
+
+// URL patterns of images that are unlikely to represent the page.
+// Hosts match subdomains too and may be followed by a path. Paths match whole segments:
+// the ones ending with a slash match a directory, the rest the file name.
+var (
+	trackerHosts = []string{
+		"facebook.com/tr", "google-analytics.com", "googletagmanager.com", "doubleclick.net",
+		"scorecardresearch.com", "quantserve.com",
+	}
+	trackerPaths = []string{
+		"/pixel.gif", "/pixel.png", "/beacon.gif", "/spacer.gif", "/blank.gif", "/transparent.gif",
+		"/1x1.gif", "/1x1.png",
+	}
+	adHosts      = []string{"googlesyndication.com", "amazon-adsystem.com", "adnxs.com"}
+	adPaths      = []string{"/ad/", "/ads/", "/adserver/"}
+	iconPatterns = []string{"sprite", "logo", "icon", "avatar", "emoji", "badge"} // parts of the path
+)
+
+// Candidate is an image of a website and the score it was ranked by
+type Candidate struct {
+	URL         string `json:"url"`
+	Source      string `json:"source"`           // the tag the image was found in, e.g. og:image
+	Width       int    `json:"width,omitempty"`  // hinted by the page or probed, 0 if unknown
+	Height      int    `json:"height,omitempty"` // hinted by the page or probed, 0 if unknown
+	Score       int    `json:"score"`
+	Rank        int    `json:"rank"`   // 1 for the best candidate
+	Reason      string `json:"reason"` // what the score is made of
+	probed      bool
+	unavailable bool
+}
+
+// RankImages returns the images of the website from the most to the least likely to represent it.
+// Images without size hints are probed (MaxProbes at most) by downloading only the beginning of them,
+// which may take up to half of the time left until the deadline of the context.
+func RankImages(ctx context.Context, s *siteinfo.SiteInfo, opts thumb.Options) []Candidate {
+	candidates := make([]Candidate, 0, len(s.Images))
+	for i, url := range s.Images {
+		c := Candidate{URL: url, Source: s.ImageSource(i)}
+		c.Width, c.Height = s.ImageSize(i)
+		candidates = append(candidates, c)
+	}
+
+	probeImages(ctx, candidates)
+	for i := range candidates {
+		candidates[i].score(opts)
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].Score > candidates[j].Score
+	})
+	for i := range candidates {
+		candidates[i].Rank = i + 1
+	}
+	return candidates
+}
+
+// probeImages reads the dimensions of the candidates that have no size hints at the same time
+func probeImages(ctx context.Context, candidates []Candidate) {
+	if deadline, ok := ctx.Deadline(); ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Until(deadline)/2)
+		defer cancel()
+	}
+
+	var wg sync.WaitGroup
+	probes := 0
+	for i := range candidates {
+		c := &candidates[i]
+		if (c.Width > 0 && c.Height > 0) || probes >= MaxProbes {
+			continue
+		}
+		probes++
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() {
+				// a decoder panicking on a broken image would otherwise bring down the server
+				if recover() != nil {
+					c.Width, c.Height, c.probed = 0, 0, false
+					c.unavailable = true
+				}
+			}()
+			c.probe(ctx)
+		}()
+	}
+	wg.Wait()
+}
+
+// probe downloads the beginning of the image to decode its dimensions.
+// Images that can't be downloaded are marked unavailable, while unknown formats (like SVG) are left unsized.
+func (c *Candidate) probe(ctx context.Context) {
+	resp, err := fetch.Get(ctx, c.URL, "image/*")
+	if err != nil {
+		c.unavailable = ctx.Err() == nil
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		c.unavailable = true
+		return
+	}
+
+	config, _, err := image.DecodeConfig(io.LimitReader(resp.Body, probeSize))
+	if err != nil {
+		return
+	}
+	c.Width, c.Height = config.Width, config.Height
+	c.probed = true
+}
+
+// score rates the candidate by its source, its dimensions and its URL
+func (c *Candidate) score(opts thumb.Options) {
+	var reasons []string
+	add := func(points int, reason string) {
+		c.Score += points
+		reasons = append(reasons, fmt.Sprintf("%s %+d", reason, points))
+	}
+
+	add(10*(siteinfo.LowestPriority()-siteinfo.Priority(c.Source)), c.Source)
+
+	size := "hinted"
+	if c.probed {
+		size = "probed"
+	}
+	w, h := c.Width, c.Height
+	switch {
+	case c.unavailable:
+		add(-1000, "unavailable")
+	case w == 0 || h == 0:
+		reasons = append(reasons, "unknown size")
+	case w < 50 || h < 50:
+		add(-100, fmt.Sprintf("tiny %dx%d (%s)", w, h, size))
+	default:
+		reasons = append(reasons, fmt.Sprintf("%dx%d (%s)", w, h, size))
+		if w > 3*h || h > 3*w {
+			add(-40, "extreme aspect ratio")
+		}
+		if w >= int(opts.Width) || h >= int(opts.Height) {
+			add(20, "large enough")
+		} else if 2*w < int(opts.Width) && 2*h < int(opts.Height) {
+			add(-20, "small")
+		}
+	}
+
+	if u, err := url.Parse(c.URL); err == nil {
+		host, path := strings.ToLower(u.Hostname()), strings.ToLower(u.Path)
+		if matchesHost(host, path, trackerHosts) || matchesPath(path, trackerPaths) {
+			add(-200, "tracker")
+		}
+		if matchesHost(host, path, adHosts) || matchesPath(path, adPaths) {
+			add(-50, "ad")
+		}
+		for _, p := range iconPatterns {
+			if strings.Contains(path, p) {
+				add(-30, "icon or logo")
+				break
+			}
+		}
+	}
+
+	c.Reason = strings.Join(reasons, ", ")
+}
+
+// matchesHost tells if the host is one of the patterns (or their subdomain) and the path matches the path of the pattern
+func matchesHost(host, path string, patterns []string) bool {
+	for _, p := range patterns {
+		domain, prefix := p, ""
+		if i := strings.IndexByte(p, '/'); i >= 0 {
+			domain, prefix = p[:i], p[i:]
+		}
+		if host != domain && !strings.HasSuffix(host, "."+domain) {
+			continue
+		}
+		if len(prefix) == 0 || path == prefix || strings.HasPrefix(path, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesPath tells if the path has a directory (like /ad/) or file name (like /pixel.gif) of the patterns
+func matchesPath(path string, patterns []string) bool {
+	dir, file := path, ""
+	if i := strings.LastIndexByte(path, '/'); i >= 0 {
+		dir, file = path[:i+1], path[i+1:]
+	}
+	for _, p := range patterns {
+		if (strings.HasSuffix(p, "/") && strings.Contains(dir, p)) || "/"+file == p {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"container/list"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/razzie/mediaserver/media"
+)
+
+// MemoryStore is a Store that keeps the least recently used Media in memory
+type MemoryStore struct {
+	ExpirationTime time.Duration
+	MaxItems       int
+	MaxSize        int64
+	mtx            sync.Mutex
+	items          map[string]*list.Element
+	lru            list.List
+	size           int64
+}
+
+type memoryItem struct {
+	key     string
+	media   *media.Media
+	size    int64
+	expires time.Time
+}
+
+// NewMemoryStore returns a new MemoryStore.
+// The max-items and max-size (bytes) query parameters limit the number of items and their total size.
+func NewMemoryStore(query url.Values, expiration time.Duration) (*MemoryStore, error) {
+	store := &MemoryStore{
+		ExpirationTime: expiration,
+		MaxItems:       1000,
+		MaxSize:        256 << 20,
+		items:          make(map[string]*list.Element),
+	}
+
+	if maxItems := query.Get("max-items"); len(maxItems) > 0 {
+		n, err := strconv.Atoi(maxItems)
+		if err != nil {
+			return nil, err
+		}
+		store.MaxItems = n
+	}
+
+	if maxSize := query.Get("max-size"); len(maxSize) > 0 {
+		n, err := strconv.ParseInt(maxSize, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		store.MaxSize = n
+	}
+
+	return store, nil
+}
+
+// GetMedia returns a saved Media
+func (s *MemoryStore) GetMedia(key string) (*media.Media, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	elem, ok := s.items[key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	item := elem.Value.(*memoryItem)
+	if time.Now().After(item.expires) {
+		s.remove(elem)
+		return nil, ErrNotFound
+	}
+
+	s.lru.MoveToFront(elem)
+	return item.media, nil
+}
+
+// SetMedia saves a Media and evicts the least recently used ones if the store is full
+func (s *MemoryStore) SetMedia(key string, m *media.Media) error {
+	item := &memoryItem{
+		key:     key,
+		media:   m,
+		size:    mediaSize(m),
+		expires: time.Now().Add(expirationOf(m, s.ExpirationTime)),
+	}
+
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if elem, ok := s.items[key]; ok {
+		s.remove(elem)
+	}
+
+	s.items[key] = s.lru.PushFront(item)
+	s.size += item.size
+
+	for s.lru.Len() > 1 && (s.lru.Len() > s.MaxItems || s.size > s.MaxSize) {
+		s.remove(s.lru.Back())
+	}
+
+	return nil
+}
+
+// Delete removes a saved Media
+func (s *MemoryStore) Delete(key string) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if elem, ok := s.items[key]; ok {
+		s.remove(elem)
+	}
+	return nil
+}
+
+// Stats returns the number of items and their estimated size
+func (s *MemoryStore) Stats() (*StoreStats, error) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	return &StoreStats{
+		Backend: "memory",
+		Items:   int64(s.lru.Len()),
+		Size:    s.size,
+	}, nil
+}
+
+func (s *MemoryStore) remove(elem *list.Element) {
+	item := s.lru.Remove(elem).(*memoryItem)
+	delete(s.items, item.key)
+	s.size -= item.size
+}
+
+func mediaSize(m *media.Media) int64 {
+	size := int64(1024) // rough estimate of the overhead and site info
+	if m.Thumbnail != nil {
+		size += int64(len(m.Thumbnail.Data))
+	}
+	return size
+}
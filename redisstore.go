@@ -0,0 +1,126 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v7"
+	"github.com/razzie/mediaserver/media"
+)
+
+// RedisStore is a Store that keeps Media in Redis
+type RedisStore struct {
+	ExpirationTime time.Duration
+	client         *redis.Client
+	lockID         string
+}
+
+var unlockScript = redis.NewScript(`
+if redis.call("get", KEYS[1]) == ARGV[1] then
+	return redis.call("del", KEYS[1])
+end
+return 0`)
+
+// NewRedisStore returns a new RedisStore
+func NewRedisStore(redisUrl string, expiration time.Duration) (*RedisStore, error) {
+	opt, err := redis.ParseURL(redisUrl)
+	if err != nil {
+		return nil, err
+	}
+
+	client := redis.NewClient(opt)
+
+	if err := client.Ping().Err(); err != nil {
+		client.Close()
+		return nil, err
+	}
+
+	var id [16]byte
+	rand.Read(id[:])
+
+	return &RedisStore{
+		ExpirationTime: expiration,
+		client:         client,
+		lockID:         hex.EncodeToString(id[:]),
+	}, nil
+}
+
+// GetMedia returns a saved Media
+func (db *RedisStore) GetMedia(key string) (*media.Media, error) {
+	data, err := db.client.Get(key).Result()
+	if err == redis.Nil {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var m media.Media
+	if err := json.Unmarshal([]byte(data), &m); err != nil {
+		return nil, err
+	}
+
+	return &m, nil
+}
+
+// SetMedia saves a Media
+func (db *RedisStore) SetMedia(key string, m *media.Media) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+
+	expiration := expirationOf(m, db.ExpirationTime)
+	return db.client.SetNX(key, string(data), expiration).Err()
+}
+
+// Delete removes a saved Media
+func (db *RedisStore) Delete(key string) error {
+	return db.client.Del(key).Err()
+}
+
+// Lock tries to acquire a lock for the given key that expires after ttl
+func (db *RedisStore) Lock(key string, ttl time.Duration) (bool, error) {
+	return db.client.SetNX("lock:"+key, db.lockID, ttl).Result()
+}
+
+// Unlock releases a lock acquired by this instance
+func (db *RedisStore) Unlock(key string) error {
+	return unlockScript.Run(db.client, []string{"lock:" + key}, db.lockID).Err()
+}
+
+// Stats returns the number of keys and the memory used by Redis
+func (db *RedisStore) Stats() (*StoreStats, error) {
+	items, err := db.client.DBSize().Result()
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := db.client.Info("memory").Result()
+	if err != nil {
+		return nil, err
+	}
+
+	return &StoreStats{
+		Backend: "redis",
+		Items:   items,
+		Size:    parseUsedMemory(info),
+	}, nil
+}
+
+func parseUsedMemory(info string) int64 {
+	scanner := bufio.NewScanner(strings.NewReader(info))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "used_memory:") {
+			size, _ := strconv.ParseInt(line[len("used_memory:"):], 10, 64)
+			return size
+		}
+	}
+	return 0
+}
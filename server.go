@@ -2,24 +2,44 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
 	"log"
 	"net"
 	"net/http"
+	"net/url"
+	"sort"
 	"strings"
+	"time"
 
+	"github.com/razzie/mediaserver/fetch"
 	"github.com/razzie/mediaserver/media"
+	"github.com/razzie/mediaserver/thumb"
 )
 
 // Server ...
 type Server struct {
-	db  *DB
-	mux http.ServeMux
+	FetchTimeout    time.Duration
+	DistributedLock bool
+	store           Store
+	opts            thumb.Options
+	flights         flightGroup
+	mux             http.ServeMux
 }
 
-// NewServer returns a new server
-func NewServer(db *DB) *Server {
-	srv := &Server{db: db}
+// NewServer returns a new server that uses opts as the default thumbnail options
+func NewServer(store Store, opts thumb.Options) *Server {
+	srv := &Server{
+		FetchTimeout: time.Minute,
+		store:        store,
+		opts:         opts,
+	}
 	srv.mux.HandleFunc("/", srv.handleRequest)
+	srv.mux.HandleFunc("/meta/", srv.handleMeta)
+	srv.mux.HandleFunc("/icon/", srv.handleIcon)
+	srv.mux.HandleFunc("/stats", srv.handleStats)
 	srv.mux.HandleFunc("/favicon.ico", func(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Not found", http.StatusNotFound)
 	})
@@ -33,31 +53,209 @@ func (srv *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 func (srv *Server) handleRequest(w http.ResponseWriter, r *http.Request) {
 	defer logRequest(r)
 
-	if len(r.RequestURI) <= 1 {
+	req, ok := srv.parseRequest(w, r, "/")
+	if !ok {
 		return
 	}
 
-	url, changed := removeSchemeFromURL(r.RequestURI[1:])
-	if changed {
-		http.Redirect(w, r, "/"+url, http.StatusSeeOther)
+	m, err := srv.getMedia(r.Context(), req)
+	if err != nil {
+		writeError(w, err)
 		return
 	}
 
-	cached, _ := srv.db.GetMedia(url)
-	if cached != nil {
-		cached.ServeHTTP(w, r)
+	m.ServeHTTP(w, r)
+}
+
+func (srv *Server) handleMeta(w http.ResponseWriter, r *http.Request) {
+	defer logRequest(r)
+
+	req, ok := srv.parseRequest(w, r, "/meta/")
+	if !ok {
+		return
+	}
+
+	m, err := srv.getMedia(r.Context(), req)
+	if m == nil || (err != nil && m.SiteInfo == nil) {
+		writeError(w, err)
+		return
+	}
+
+	m.Metadata(req.imageURL()).ServeHTTP(w, r)
+}
+
+func (srv *Server) handleIcon(w http.ResponseWriter, r *http.Request) {
+	defer logRequest(r)
+
+	req, ok := srv.parseRequest(w, r, "/icon/")
+	if !ok {
 		return
 	}
+	req.icon = true
+	req.opts.LabelSource = "none"
+	req.key = "icon:" + urlToKey(req.url, req.opts)
+
+	m, err := srv.getMedia(r.Context(), req)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	m.ServeHTTP(w, r)
+}
+
+// ServerStats contains the details of the cache and the image processing queue
+type ServerStats struct {
+	Store *StoreStats     `json:"store"`
+	Pool  thumb.PoolStats `json:"pool"`
+}
 
-	resp, err := media.GetFromURL(r.Context(), "http://"+url)
+func (srv *Server) handleStats(w http.ResponseWriter, r *http.Request) {
+	store, err := srv.store.Stats()
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
-	} else {
-		resp.ServeHTTP(w, r)
+		return
 	}
 
-	if resp != nil && err != context.Canceled {
-		srv.db.SetMedia(url, resp)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(&ServerStats{
+		Store: store,
+		Pool:  thumb.DefaultPool.Stats(),
+	})
+}
+
+type mediaRequest struct {
+	url  string
+	opts thumb.Options
+	key  string
+	icon bool
+}
+
+func (req *mediaRequest) imageURL() string {
+	return "/" + formatOptions(req.opts.Query()) + "/" + req.url
+}
+
+// parseRequest extracts the thumbnail options and the target URL from the request URI after the given prefix.
+// The options are in an optional path segment before the URL, like /w=64,fmt=webp/example.com/image.jpg
+func (srv *Server) parseRequest(w http.ResponseWriter, r *http.Request, prefix string) (*mediaRequest, bool) {
+	if len(r.RequestURI) <= len(prefix) {
+		return nil, false
+	}
+
+	url, query := splitQuery(r.RequestURI[len(prefix):])
+	options, url := splitOptions(url)
+	url, changed := removeSchemeFromURL(url)
+	if changed {
+		if len(options) > 0 {
+			prefix += options + "/"
+		}
+		http.Redirect(w, r, prefix+joinQuery(url, query), http.StatusSeeOther)
+		return nil, false
+	}
+
+	params, err := parseOptions(options)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+	opts, err := srv.opts.ParseQuery(params)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+
+	url = joinQuery(url, query)
+	if len(url) == 0 {
+		return nil, false
+	}
+
+	return &mediaRequest{
+		url:  url,
+		opts: opts,
+		key:  urlToKey(url, opts),
+	}, true
+}
+
+// getMedia returns the cached media for the request or fetches and caches it.
+// Concurrent requests for the same media share a single fetch.
+func (srv *Server) getMedia(ctx context.Context, req *mediaRequest) (*media.Media, error) {
+	cached, _ := srv.store.GetMedia(req.key)
+	if cached != nil {
+		return cached, nil
+	}
+
+	return srv.flights.Do(ctx, req.key, func() (*media.Media, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), srv.FetchTimeout)
+		defer cancel()
+
+		if locker, ok := srv.store.(Locker); ok && srv.DistributedLock {
+			cached, err := srv.waitForLock(ctx, locker, req.key)
+			if cached != nil || err != nil {
+				return cached, err
+			}
+			defer locker.Unlock(req.key)
+		}
+
+		get := media.GetFromURL
+		if req.icon {
+			get = media.GetIconFromURL
+		}
+
+		// failed media is only cached if it has site info to serve, otherwise the cache would hide the error behind a 404
+		m, err := get(ctx, "http://"+req.url, req.opts)
+		if m != nil && (err == nil || m.SiteInfo != nil) && err != context.Canceled && err != thumb.ErrBusy {
+			srv.store.SetMedia(req.key, m)
+		}
+
+		return m, err
+	})
+}
+
+// waitForLock acquires the lock of the key, or returns the media if another instance fetched it meanwhile
+func (srv *Server) waitForLock(ctx context.Context, locker Locker, key string) (*media.Media, error) {
+	for {
+		acquired, err := locker.Lock(key, srv.FetchTimeout)
+		if err != nil || acquired {
+			return nil, err
+		}
+
+		select {
+		case <-time.After(100 * time.Millisecond):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+
+		cached, _ := srv.store.GetMedia(key)
+		if cached != nil {
+			return cached, nil
+		}
+	}
+}
+
+// writeError replies with the error message and the matching status code
+func writeError(w http.ResponseWriter, err error) {
+	status := errorStatus(err)
+	if status == http.StatusServiceUnavailable {
+		w.Header().Set("Retry-After", "5")
+	}
+	http.Error(w, err.Error(), status)
+}
+
+// errorStatus returns the HTTP status code that best describes the error
+func errorStatus(err error) int {
+	switch {
+	case errors.Is(err, fetch.ErrForbiddenAddress):
+		return http.StatusForbidden
+	case errors.Is(err, media.ErrNoIcon):
+		return http.StatusNotFound
+	case errors.Is(err, thumb.ErrBusy):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, fetch.ErrTooLarge):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, thumb.ErrTooManyPixels), errors.Is(err, image.ErrFormat):
+		return http.StatusUnprocessableEntity
+	default:
+		return http.StatusInternalServerError
 	}
 }
 
@@ -79,3 +277,81 @@ func removeSchemeFromURL(url string) (result string, changed bool) {
 	}
 	return url, false
 }
+
+func splitQuery(uri string) (path, query string) {
+	if index := strings.IndexByte(uri, '?'); index != -1 {
+		return uri[:index], uri[index+1:]
+	}
+	return uri, ""
+}
+
+func joinQuery(path, query string) string {
+	if len(query) == 0 {
+		return path
+	}
+	return path + "?" + query
+}
+
+// splitOptions separates the thumbnail options segment (like w=64,fmt=webp) from the beginning of the path.
+// Host names can't contain '=', so the options never collide with the target URL or its query.
+func splitOptions(path string) (options, rest string) {
+	end := strings.IndexByte(path, '/')
+	if end == -1 || !strings.Contains(path[:end], "=") {
+		return "", path
+	}
+	return path[:end], path[end+1:]
+}
+
+// parseOptions parses a comma separated list of thumbnail options like w=64,fmt=webp
+func parseOptions(options string) (url.Values, error) {
+	params := make(url.Values)
+	for _, pair := range strings.Split(options, ",") {
+		if len(pair) == 0 {
+			continue
+		}
+		key, value := pair, ""
+		if index := strings.IndexByte(pair, '='); index != -1 {
+			key, value = pair[:index], pair[index+1:]
+		}
+		if !containsString(thumb.OptionKeys, key) {
+			return nil, fmt.Errorf("unknown option: %s", key)
+		}
+		if unescaped, err := url.QueryUnescape(value); err == nil {
+			value = unescaped
+		}
+		params.Set(key, value)
+	}
+	return params, nil
+}
+
+// formatOptions returns the options segment of the thumbnail options in the order of their keys
+func formatOptions(params url.Values) string {
+	keys := make([]string, 0, len(params))
+	for key := range params {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, 0, len(keys))
+	for _, key := range keys {
+		pairs = append(pairs, key+"="+url.QueryEscape(params.Get(key)))
+	}
+	return strings.Join(pairs, ",")
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func urlToKey(url string, opts thumb.Options) string {
+	url = strings.ToLower(url)
+	if len(url) > 0 && url[len(url)-1] == '/' {
+		url = url[:len(url)-1]
+	}
+	return url + "@" + opts.Key()
+}
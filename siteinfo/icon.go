@@ -0,0 +1,192 @@
+package siteinfo
+
+import (
+	"context"
+	"encoding/json"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/razzie/mediaserver/fetch"
+)
+
+// Icon is a site icon with the size and type hints of the tag it was found in
+type Icon struct {
+	URL   string `json:"url"`
+	Rel   string `json:"rel"`   // icon, apple-touch-icon, mask-icon or manifest
+	Sizes string `json:"sizes"` // e.g. 16x16 32x32, or any for scalable icons
+	Type  string `json:"type"`
+
+	fallback bool // /favicon.ico added by ResolveIconURLs
+}
+
+// Size returns the largest size of the icon in pixels, or a guess based on rel if it's not known
+func (icon *Icon) Size() int {
+	size := 0
+	for _, s := range strings.Fields(strings.ToLower(icon.Sizes)) {
+		if s == "any" {
+			return 1 << 16
+		}
+		if index := strings.IndexByte(s, 'x'); index != -1 {
+			if w, err := strconv.Atoi(s[:index]); err == nil && w > size {
+				size = w
+			}
+		}
+	}
+	if size > 0 {
+		return size
+	}
+
+	switch icon.Rel {
+	case "apple-touch-icon":
+		return 180
+	case "mask-icon":
+		return 1 << 16
+	default:
+		return 32
+	}
+}
+
+// processLink adds the icon, manifest or image of a link tag
+func (s *SiteInfo) processLink(attrs map[string]string) {
+	href := attrs["href"]
+	if len(href) == 0 {
+		return
+	}
+
+	if attrs["itemprop"] == "image" {
+		s.addImage(href, "itemprop:image")
+	}
+
+	for _, rel := range strings.Fields(strings.ToLower(attrs["rel"])) {
+		switch rel {
+		case "image_src":
+			s.addImage(href, "link:image_src")
+		case "icon":
+			s.Icons = append(s.Icons, Icon{URL: href, Rel: "icon", Sizes: attrs["sizes"], Type: attrs["type"]})
+		case "apple-touch-icon", "apple-touch-icon-precomposed":
+			s.Icons = append(s.Icons, Icon{URL: href, Rel: "apple-touch-icon", Sizes: attrs["sizes"], Type: attrs["type"]})
+		case "mask-icon":
+			s.Icons = append(s.Icons, Icon{URL: href, Rel: "mask-icon", Sizes: attrs["sizes"], Type: "image/svg+xml"})
+		case "manifest":
+			s.Manifest = href
+		default:
+			continue
+		}
+		return
+	}
+}
+
+// ResolveIconURLs resolves the icon and manifest URLs using the base URL of the page and removes duplicate icons.
+// /favicon.ico is always added as the last resort, unless the page links it already.
+func (s *SiteInfo) ResolveIconURLs(pageURL string) {
+	base, err := s.baseURL(pageURL)
+	if err != nil {
+		return
+	}
+
+	icons := s.Icons[:0]
+	for _, icon := range s.Icons {
+		icon.URL = resolveURL(base, icon.URL)
+		if !containsIcon(icons, icon.URL) {
+			icons = append(icons, icon)
+		}
+	}
+	s.Icons = icons
+	if len(s.Manifest) > 0 {
+		s.Manifest = resolveURL(base, s.Manifest)
+	}
+
+	favicon := &url.URL{Scheme: base.Scheme, Host: base.Host, Path: "/favicon.ico"}
+	if !containsIcon(s.Icons, favicon.String()) {
+		s.Icons = append(s.Icons, Icon{URL: favicon.String(), Rel: "icon", Type: "image/x-icon", fallback: true})
+	}
+}
+
+func containsIcon(icons []Icon, url string) bool {
+	for _, icon := range icons {
+		if icon.URL == url {
+			return true
+		}
+	}
+	return false
+}
+
+// FetchManifestIcons adds the icons listed in the web app manifest of the site (if any)
+func (s *SiteInfo) FetchManifestIcons(ctx context.Context) error {
+	if len(s.Manifest) == 0 {
+		return nil
+	}
+
+	resp, err := fetch.Get(ctx, s.Manifest, "application/manifest+json, application/json")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var manifest struct {
+		Icons []struct {
+			Src     string `json:"src"`
+			Sizes   string `json:"sizes"`
+			Type    string `json:"type"`
+			Purpose string `json:"purpose"`
+		} `json:"icons"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&manifest); err != nil {
+		return err
+	}
+
+	base := resp.Request.URL
+	for _, icon := range manifest.Icons {
+		if len(icon.Src) == 0 || strings.Contains(icon.Purpose, "monochrome") {
+			continue
+		}
+		iconURL := resolveURL(base, icon.Src)
+		if containsIcon(s.Icons, iconURL) {
+			continue
+		}
+		s.Icons = append(s.Icons, Icon{
+			URL:   iconURL,
+			Rel:   "manifest",
+			Sizes: icon.Sizes,
+			Type:  icon.Type,
+		})
+	}
+	return nil
+}
+
+// IconsBySize returns the icons in order of preference to display them at the given size:
+// the smallest ones that are at least as large, then the rest from the largest to the smallest,
+// and the /favicon.ico fallback last. Mask icons are left out as they are meant to be colored by the browser.
+func (s *SiteInfo) IconsBySize(size int) []Icon {
+	icons := make([]Icon, 0, len(s.Icons))
+	for _, icon := range s.Icons {
+		if icon.Rel != "mask-icon" {
+			icons = append(icons, icon)
+		}
+	}
+
+	sort.SliceStable(icons, func(i, j int) bool {
+		if icons[i].fallback != icons[j].fallback {
+			return icons[j].fallback
+		}
+		si, sj := icons[i].Size(), icons[j].Size()
+		if (si >= size) != (sj >= size) {
+			return si >= size
+		}
+		if si >= size {
+			return si < sj
+		}
+		return si > sj
+	})
+	return icons
+}
+
+func resolveURL(base *url.URL, ref string) string {
+	refURL, err := url.Parse(strings.TrimSpace(ref))
+	if err != nil {
+		return ref
+	}
+	return base.ResolveReference(refURL).String()
+}
@@ -0,0 +1,307 @@
+package siteinfo
+
+import (
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// defaultImageWidth is the width responsive images are picked for until SelectImageSize is called
+const defaultImageWidth = 512
+
+// lazyImageAttrs are the attributes lazy loading scripts copy to src, in order of preference
+var lazyImageAttrs = []string{"data-src", "data-original", "data-lazy-src"}
+
+// unsupportedImageTypes are the picture sources that can't be made into a thumbnail
+var unsupportedImageTypes = map[string]bool{
+	"image/avif": true,
+	"image/heic": true,
+	"image/heif": true,
+	"image/jxl":  true,
+}
+
+// imageCandidate is one of the alternative URLs of an image, e.g. an entry of a srcset
+type imageCandidate struct {
+	url     string
+	width   int     // w descriptor, 0 if unknown
+	density float64 // x descriptor, 1 if unknown
+}
+
+// imageHint holds the details of an image besides its URL
+type imageHint struct {
+	source     string
+	candidates []imageCandidate // responsive variants of the image
+	width      int              // width attribute of the img tag or og:image:width
+	height     int              // height attribute of the img tag or og:image:height
+}
+
+// processImg adds the image of an img tag, sources being the candidates of the enclosing picture tag (if any).
+// Lazy loading attributes are preferred over src, as src is usually a placeholder in that case.
+func (s *SiteInfo) processImg(attrs map[string]string, sources []imageCandidate) {
+	candidates := append([]imageCandidate(nil), sources...)
+	candidates = append(candidates, parseSrcset(attrs["srcset"])...)
+	candidates = append(candidates, parseSrcset(attrs["data-srcset"])...)
+
+	src := attrs["src"]
+	for _, attr := range lazyImageAttrs {
+		if isImageURL(attrs[attr]) {
+			src = attrs[attr]
+			break
+		}
+	}
+	if isImageURL(src) {
+		candidates = append(candidates, imageCandidate{url: strings.TrimSpace(src), density: 1})
+	}
+	if len(candidates) == 0 {
+		return
+	}
+
+	hint := imageHint{source: "img", candidates: candidates}
+	hint.width = parseDimension(attrs["width"])
+	hint.height = parseDimension(attrs["height"])
+	if attrs["itemprop"] == "image" {
+		hint.source = "itemprop:image"
+	}
+	s.addImageHint(chooseCandidate(candidates, hint.width, defaultImageWidth).url, hint)
+}
+
+// processPictureSource returns the candidates of a source tag inside a picture tag
+func processPictureSource(attrs map[string]string) []imageCandidate {
+	if unsupportedImageTypes[strings.ToLower(strings.TrimSpace(attrs["type"]))] {
+		return nil
+	}
+	if srcset := attrs["srcset"]; len(srcset) > 0 {
+		return parseSrcset(srcset)
+	}
+	return parseSrcset(attrs["data-srcset"])
+}
+
+// processNoscript adds the images of a noscript tag, which is the fallback of lazy loaded images
+func (s *SiteInfo) processNoscript(content string) {
+	z := html.NewTokenizer(strings.NewReader(content))
+	var sources []imageCandidate
+	for {
+		tt := z.Next()
+		switch tt {
+		case html.ErrorToken:
+			return
+
+		case html.StartTagToken, html.SelfClosingTagToken:
+			name, hasAttr := z.TagName()
+			a := atom.Lookup(name)
+			if a != atom.Img && a != atom.Source {
+				continue
+			}
+
+			m := make(map[string]string)
+			var key, val []byte
+			for hasAttr {
+				key, val, hasAttr = z.TagAttr()
+				m[atom.String(key)] = string(val)
+			}
+
+			if a == atom.Source {
+				sources = append(sources, processPictureSource(m)...)
+			} else {
+				s.processImg(m, sources)
+				sources = nil
+			}
+
+		case html.EndTagToken:
+			if name, _ := z.TagName(); atom.Lookup(name) == atom.Picture {
+				sources = nil
+			}
+		}
+	}
+}
+
+// parseSrcset returns the image candidates of a srcset attribute, like "a.jpg 480w, b.jpg 800w"
+func parseSrcset(srcset string) []imageCandidate {
+	var candidates []imageCandidate
+	for pos := 0; pos < len(srcset); {
+		for pos < len(srcset) && (isSpace(srcset[pos]) || srcset[pos] == ',') {
+			pos++
+		}
+		start := pos
+		for pos < len(srcset) && !isSpace(srcset[pos]) {
+			pos++
+		}
+		url := srcset[start:pos]
+
+		// an URL ending with a comma has no descriptors
+		descriptors := ""
+		if strings.HasSuffix(url, ",") {
+			url = strings.TrimRight(url, ",")
+		} else {
+			start = pos
+			depth := 0
+			for pos < len(srcset) && (depth > 0 || srcset[pos] != ',') {
+				switch srcset[pos] {
+				case '(':
+					depth++
+				case ')':
+					if depth > 0 {
+						depth--
+					}
+				}
+				pos++
+			}
+			descriptors = srcset[start:pos]
+		}
+
+		if !isImageURL(url) {
+			continue
+		}
+
+		c := imageCandidate{url: url, density: 1}
+		for _, d := range strings.Fields(descriptors) {
+			value, err := strconv.ParseFloat(d[:len(d)-1], 64)
+			if err != nil || value <= 0 {
+				continue
+			}
+			switch d[len(d)-1] {
+			case 'w':
+				c.width = int(value)
+			case 'x':
+				c.density = value
+			}
+		}
+		candidates = append(candidates, c)
+	}
+	return candidates
+}
+
+// chooseCandidate returns the smallest candidate that is at least as wide as the target width, or the widest one.
+// The width of density (x) candidates is only known if the img tag has a width attribute (displayWidth),
+// otherwise the sharpest one up to 2x is chosen.
+func chooseCandidate(candidates []imageCandidate, displayWidth, target int) imageCandidate {
+	best, bestWidth := -1, 0
+	for i, c := range candidates {
+		width := c.width
+		if width == 0 && displayWidth > 0 {
+			width = int(c.density * float64(displayWidth))
+		}
+		if width == 0 {
+			continue
+		}
+		if best == -1 || closerWidth(width, bestWidth, target) {
+			best, bestWidth = i, width
+		}
+	}
+	if best != -1 {
+		return candidates[best]
+	}
+
+	for i, c := range candidates {
+		if best == -1 || (c.density <= 2 && c.density > candidates[best].density) ||
+			(candidates[best].density > 2 && c.density < candidates[best].density) {
+			best = i
+		}
+	}
+	return candidates[best]
+}
+
+// closerWidth tells if width suits the target better than the current best width
+func closerWidth(width, best, target int) bool {
+	switch {
+	case width >= target && best >= target:
+		return width < best
+	case width >= target:
+		return true
+	case best >= target:
+		return false
+	default:
+		return width > best
+	}
+}
+
+// setImageDimension sets the width or height of the last OpenGraph image, like og:image:width does
+func (s *SiteInfo) setImageDimension(property, value string) {
+	for i := len(s.imageHints) - 1; i >= 0; i-- {
+		hint := &s.imageHints[i]
+		if vocabularyOf(hint.source) != "og" {
+			continue
+		}
+		if strings.HasSuffix(property, ":width") {
+			hint.width = parseDimension(value)
+		} else {
+			hint.height = parseDimension(value)
+		}
+		return
+	}
+}
+
+// ImageSize returns the dimensions of the i-th image in pixels if the page hints them, 0 otherwise
+func (s *SiteInfo) ImageSize(i int) (width, height int) {
+	if i >= len(s.imageHints) || i >= len(s.Images) {
+		return 0, 0
+	}
+
+	hint := s.imageHints[i]
+	width, height = hint.width, hint.height
+	for _, c := range hint.candidates {
+		if c.url != s.Images[i] {
+			continue
+		}
+		if c.width > 0 {
+			if width > 0 {
+				height = height * c.width / width
+			} else {
+				height = 0
+			}
+			width = c.width
+		} else {
+			width = int(float64(width) * c.density)
+			height = int(float64(height) * c.density)
+		}
+		break
+	}
+	return width, height
+}
+
+// SelectImageSize picks the variant of each responsive image that suits a thumbnail of the given width the best
+func (s *SiteInfo) SelectImageSize(width int) {
+	for i, hint := range s.imageHints {
+		if i < len(s.Images) && len(hint.candidates) > 0 {
+			s.Images[i] = chooseCandidate(hint.candidates, hint.width, width).url
+		}
+	}
+}
+
+// ResolveImageURLs resolves the potentially relative image URLs against the base tag and the URL of the page
+func (s *SiteInfo) ResolveImageURLs(pageURL string) {
+	base, err := s.baseURL(pageURL)
+	if err != nil {
+		return
+	}
+
+	for i := range s.Images {
+		s.Images[i] = resolveURL(base, s.Images[i])
+	}
+	for _, hint := range s.imageHints {
+		for j := range hint.candidates {
+			hint.candidates[j].url = resolveURL(base, hint.candidates[j].url)
+		}
+	}
+}
+
+// isImageURL tells if the URL can be fetched, as opposed to being empty or an inline data: placeholder
+func isImageURL(url string) bool {
+	url = strings.TrimSpace(url)
+	return len(url) > 0 && !strings.HasPrefix(strings.ToLower(url), "data:")
+}
+
+// parseDimension returns the number of pixels in a width or height attribute, 0 if it's missing or relative
+func parseDimension(value string) int {
+	n, err := strconv.Atoi(strings.TrimSuffix(strings.TrimSpace(value), "px"))
+	if err != nil || n < 0 {
+		return 0
+	}
+	return n
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f'
+}
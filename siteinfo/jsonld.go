@@ -0,0 +1,167 @@
+package siteinfo
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+)
+
+// processJSONLD extracts the details of the schema.org types found in a JSON-LD script
+func (s *SiteInfo) processJSONLD(data []byte) {
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.UseNumber()
+
+	var v interface{}
+	if err := d.Decode(&v); err != nil {
+		return
+	}
+	s.processJSONLDValue(v)
+}
+
+func (s *SiteInfo) processJSONLDValue(v interface{}) {
+	switch v := v.(type) {
+	case []interface{}:
+		for _, item := range v {
+			s.processJSONLDValue(item)
+		}
+	case map[string]interface{}:
+		if graph, ok := v["@graph"]; ok {
+			s.processJSONLDValue(graph)
+		}
+		s.processJSONLDNode(v)
+	}
+}
+
+// processJSONLDNode extracts the details of a node if it's one of the supported types
+func (s *SiteInfo) processJSONLDNode(node map[string]interface{}) {
+	typ := jsonldType(node)
+	source := "jsonld:" + typ
+	switch typ {
+	case "Article", "NewsArticle", "BlogPosting", "Product", "VideoObject", "Recipe":
+	case "Organization":
+		s.set(&s.SiteName, "site_name", jsonldString(node["name"]), source)
+		for _, img := range jsonldImages(node["logo"]) {
+			s.addImage(img, "logo:"+typ) // only better than random images of the page
+		}
+		return
+	default:
+		return
+	}
+
+	title := jsonldString(node["headline"])
+	if len(title) == 0 {
+		title = jsonldString(node["name"])
+	}
+	s.set(&s.Title, "title", title, source)
+	s.set(&s.Description, "description", jsonldString(node["description"]), source)
+	s.set(&s.URL, "url", jsonldString(node["url"]), source)
+	s.set(&s.Author, "author", jsonldNames(node["author"]), source)
+
+	published := jsonldString(node["datePublished"])
+	if len(published) == 0 {
+		published = jsonldString(node["uploadDate"])
+	}
+	s.set(&s.Published, "published", published, source)
+
+	if offer := jsonldFirst(node["offers"]); offer != nil {
+		price := jsonldString(offer["price"])
+		if len(price) == 0 {
+			price = jsonldString(offer["lowPrice"])
+		}
+		s.set(&s.Price, "price", price, source)
+		s.set(&s.Currency, "currency", jsonldString(offer["priceCurrency"]), source)
+	}
+	if rating := jsonldFirst(node["aggregateRating"]); rating != nil {
+		s.set(&s.Rating, "rating", jsonldString(rating["ratingValue"]), source)
+	}
+
+	for _, img := range jsonldImages(node["image"]) {
+		s.addImage(img, source)
+	}
+	for _, img := range jsonldImages(node["thumbnailUrl"]) {
+		s.addImage(img, source)
+	}
+}
+
+// jsonldType returns the first type of the node without the schema.org prefix
+func jsonldType(node map[string]interface{}) string {
+	typ := node["@type"]
+	if types, ok := typ.([]interface{}); ok && len(types) > 0 {
+		typ = types[0]
+	}
+	name, _ := typ.(string)
+	if index := strings.LastIndexAny(name, "/:"); index != -1 {
+		name = name[index+1:]
+	}
+	return name
+}
+
+// jsonldString returns a text or number value as string
+func jsonldString(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	case []interface{}:
+		if len(v) > 0 {
+			return jsonldString(v[0])
+		}
+	}
+	return ""
+}
+
+// jsonldFirst returns the value if it's a node, or the first node of an array
+func jsonldFirst(v interface{}) map[string]interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		return v
+	case []interface{}:
+		for _, item := range v {
+			if node, ok := item.(map[string]interface{}); ok {
+				return node
+			}
+		}
+	}
+	return nil
+}
+
+// jsonldNames returns the comma separated names of people or organizations
+func jsonldNames(v interface{}) string {
+	var names []string
+	switch v := v.(type) {
+	case string:
+		names = append(names, v)
+	case map[string]interface{}:
+		names = append(names, jsonldString(v["name"]))
+	case []interface{}:
+		for _, item := range v {
+			if name := jsonldNames(item); len(name) > 0 {
+				names = append(names, name)
+			}
+		}
+	}
+	return strings.Join(names, ", ")
+}
+
+// jsonldImages returns the URLs of an image value, which can be a URL, an ImageObject or an array of them
+func jsonldImages(v interface{}) []string {
+	switch v := v.(type) {
+	case string:
+		return []string{v}
+	case map[string]interface{}:
+		if url := jsonldString(v["url"]); len(url) > 0 {
+			return []string{url}
+		}
+		if url := jsonldString(v["contentUrl"]); len(url) > 0 {
+			return []string{url}
+		}
+	case []interface{}:
+		var images []string
+		for _, item := range v {
+			images = append(images, jsonldImages(item)...)
+		}
+		return images
+	}
+	return nil
+}
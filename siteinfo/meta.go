@@ -0,0 +1,144 @@
+package siteinfo
+
+import (
+	"sort"
+	"strings"
+)
+
+// vocabularies in order of priority, a field found in a vocabulary is only overridden by a higher priority one
+var vocabularies = []string{"og", "twitter", "jsonld", "itemprop", "link", "logo", "dc", "html"}
+
+// vocabularyOf returns the vocabulary of a source like og:title.
+// Dublin Core terms (dcterms.title) belong to the same vocabulary as the elements (dc.title).
+func vocabularyOf(source string) string {
+	if index := strings.IndexAny(source, ":."); index != -1 {
+		source = source[:index]
+	}
+	if source == "dcterms" {
+		return "dc"
+	}
+	return source
+}
+
+// Priority returns the priority of a source like og:image, 0 being the highest.
+// Sources of no known vocabulary (like img) get the lowest priority, which is LowestPriority().
+func Priority(source string) int {
+	vocabulary := vocabularyOf(source)
+	for i, v := range vocabularies {
+		if v == vocabulary {
+			return i
+		}
+	}
+	return len(vocabularies)
+}
+
+// LowestPriority returns the priority of sources of no known vocabulary
+func LowestPriority() int {
+	return len(vocabularies)
+}
+
+// set updates the field unless it was already set from a source with a higher priority
+func (s *SiteInfo) set(field *string, name, value, source string) {
+	value = strings.TrimSpace(value)
+	if len(value) == 0 {
+		return
+	}
+	if prev, ok := s.Sources[name]; ok && Priority(prev) <= Priority(source) {
+		return
+	}
+	if s.Sources == nil {
+		s.Sources = make(map[string]string)
+	}
+	*field = value
+	s.Sources[name] = source
+}
+
+// addImage adds an image candidate found in the given source
+func (s *SiteInfo) addImage(url, source string) {
+	s.addImageHint(url, imageHint{source: source})
+}
+
+// addImageHint adds an image unless it's already known, in which case only the source may be upgraded
+func (s *SiteInfo) addImageHint(url string, hint imageHint) {
+	url = strings.TrimSpace(url)
+	if !isImageURL(url) {
+		return
+	}
+	for i, img := range s.Images {
+		if img == url {
+			if Priority(hint.source) < Priority(s.imageHints[i].source) {
+				s.imageHints[i].source = hint.source
+			}
+			return
+		}
+	}
+	s.Images = append(s.Images, url)
+	s.imageHints = append(s.imageHints, hint)
+}
+
+// ImageSource returns the source the i-th image was found in
+func (s *SiteInfo) ImageSource(i int) string {
+	if i < len(s.imageHints) {
+		return s.imageHints[i].source
+	}
+	return ""
+}
+
+// sortImages orders the images by the priority of their sources, keeping the document order otherwise
+func (s *SiteInfo) sortImages() {
+	if len(s.imageHints) != len(s.Images) {
+		return
+	}
+	sort.Stable(imagesByPriority{s})
+	if len(s.imageHints) > 0 {
+		if s.Sources == nil {
+			s.Sources = make(map[string]string)
+		}
+		s.Sources["images"] = s.imageHints[0].source
+	}
+}
+
+type imagesByPriority struct {
+	s *SiteInfo
+}
+
+func (x imagesByPriority) Len() int {
+	return len(x.s.Images)
+}
+
+func (x imagesByPriority) Less(i, j int) bool {
+	return Priority(x.s.imageHints[i].source) < Priority(x.s.imageHints[j].source)
+}
+
+func (x imagesByPriority) Swap(i, j int) {
+	x.s.Images[i], x.s.Images[j] = x.s.Images[j], x.s.Images[i]
+	x.s.imageHints[i], x.s.imageHints[j] = x.s.imageHints[j], x.s.imageHints[i]
+}
+
+// processMetaTag handles the attributes of a meta tag in any of the known vocabularies
+func (s *SiteInfo) processMetaTag(attrs map[string]string) {
+	content := attrs["content"]
+	if property := attrs["property"]; len(property) > 0 {
+		s.ProcessMeta(property, content)
+	}
+	if name := attrs["name"]; len(name) > 0 {
+		s.ProcessMeta(name, content)
+	}
+	if itemprop := attrs["itemprop"]; len(itemprop) > 0 {
+		s.processItemprop(itemprop, content)
+	}
+}
+
+// processItemprop handles schema.org microdata properties
+func (s *SiteInfo) processItemprop(itemprop, content string) {
+	switch itemprop {
+	case "name", "headline":
+		s.set(&s.Title, "title", content, "itemprop:"+itemprop)
+	case "description":
+		s.set(&s.Description, "description", content, "itemprop:description")
+	case "url":
+		s.set(&s.URL, "url", content, "itemprop:url")
+	case "image", "thumbnailUrl":
+		s.addImage(content, "itemprop:"+itemprop)
+	}
+}
@@ -1,83 +1,116 @@
 package siteinfo
 
 import (
+	"bufio"
+	"bytes"
 	"context"
 	"io"
-	"net/http"
 	"net/url"
-	"path"
 	"strings"
+	"unicode/utf8"
 
+	"github.com/razzie/mediaserver/fetch"
 	"golang.org/x/net/html"
 	"golang.org/x/net/html/atom"
+	"golang.org/x/net/html/charset"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/transform"
 )
 
+// utf8SniffLen is the number of bytes checked to be valid UTF-8 when the page doesn't declare its charset
+const utf8SniffLen = 64 << 10
+
 // SiteInfo holds the most typical details about a website (if found)
 type SiteInfo struct {
-	Type        string   `json:"type"`
-	URL         string   `json:"url"`
-	Title       string   `json:"title"`
-	Description string   `json:"description"`
-	Images      []string `json:"images"`
+	Type        string            `json:"type"`
+	URL         string            `json:"url"`
+	Title       string            `json:"title"`
+	SiteName    string            `json:"site_name"`
+	Description string            `json:"description"`
+	Author      string            `json:"author"`
+	Published   string            `json:"published"`
+	Price       string            `json:"price"`
+	Currency    string            `json:"currency"`
+	Rating      string            `json:"rating"`
+	Images      []string          `json:"images"`
+	Icons       []Icon            `json:"icons"`
+	Manifest    string            `json:"manifest"`
+	Sources     map[string]string `json:"sources"` // the tag each field was taken from, e.g. title: og:title
+	Charset     string            `json:"charset"` // the original encoding of the page
+	base        string
+	imageHints  []imageHint
 }
 
-// ProcessMeta updates the SiteInfo based on OpenGraph property name and content
+// ProcessMeta updates the SiteInfo based on the property name and content of a meta tag.
+// OpenGraph, Twitter Card and Dublin Core properties are understood, in this order of priority.
 func (s *SiteInfo) ProcessMeta(property, content string) {
 	if len(content) == 0 {
 		return
 	}
 
+	property = strings.ToLower(property)
 	switch property {
-	case "og:description":
-		s.Description = content
+	case "og:description", "twitter:description", "dc.description", "dcterms.description", "description":
+		s.set(&s.Description, "description", content, property)
 	case "og:type":
-		s.Type = content
-	case "og:title":
-		s.Title = content
-	case "og:url":
-		s.URL = content
-	case "og:image", "og:image:url":
-		s.Images = append(s.Images, content)
+		s.set(&s.Type, "type", content, property)
+	case "og:title", "twitter:title", "dc.title", "dcterms.title":
+		s.set(&s.Title, "title", content, property)
+	case "og:site_name":
+		s.set(&s.SiteName, "site_name", content, property)
+	case "og:url", "twitter:url":
+		s.set(&s.URL, "url", content, property)
+	case "og:image", "og:image:url", "og:image:secure_url", "twitter:image", "twitter:image:src":
+		s.addImage(content, property)
+	case "og:image:width", "og:image:height":
+		s.setImageDimension(property, content)
 	}
 }
 
-func resolveImageURL(img *string, hostname string) error {
-	if strings.Contains(*img, "://") {
-		return nil
-	}
-
-	if strings.HasPrefix(*img, "//") {
-		*img = "http:" + *img
-		return nil
-	}
-
-	imgURL, err := url.Parse(*img)
+// baseURL returns the URL relative links of the page are resolved against
+func (s *SiteInfo) baseURL(pageURL string) (*url.URL, error) {
+	base, err := url.Parse(pageURL)
 	if err != nil {
-		return err
+		return nil, err
 	}
-
-	hostURL, err := url.Parse(hostname)
-	if err != nil {
-		return err
+	if len(s.base) > 0 {
+		if baseURL, err := url.Parse(strings.TrimSpace(s.base)); err == nil {
+			base = base.ResolveReference(baseURL)
+		}
 	}
+	return base, nil
+}
 
-	*img = hostURL.ResolveReference(imgURL).String()
-	return nil
+// Get returns SiteInfo from an io.Reader that contains HTML
+func Get(buffer io.Reader) (*SiteInfo, error) {
+	return GetWithContentType(buffer, "")
 }
 
-// ResolveImageURLs resolves a potentially relative image URLs using the given hostname
-func (s *SiteInfo) ResolveImageURLs(hostname string) {
-	for i := 0; i < len(s.Images); i++ {
-		img := &s.Images[i]
-		resolveImageURL(img, hostname)
+// GetWithContentType returns SiteInfo from an io.Reader that contains HTML.
+// The page is transcoded to UTF-8 based on the charset of the Content-Type header,
+// the BOM or meta tags of the page, or the content itself (in this order).
+func GetWithContentType(buffer io.Reader, contentType string) (*SiteInfo, error) {
+	br := bufio.NewReaderSize(buffer, utf8SniffLen)
+	head, _ := br.Peek(1024)
+	enc, name, certain := charset.DetermineEncoding(head, contentType)
+	if !certain && name == "windows-1252" && !declaresCharset(head) {
+		// windows-1252 is only a guess when the first 1024 bytes are ASCII, so more of the page is checked
+		if more, _ := br.Peek(utf8SniffLen); validUTF8(more) {
+			enc, name = encoding.Nop, "utf-8"
+		}
 	}
-}
 
-// Get returns SiteInfo from an io.Reader that contains HTML
-func Get(buffer io.Reader) (*SiteInfo, error) {
-	s := &SiteInfo{}
-	z := html.NewTokenizer(buffer)
-	base := ""
+	s := &SiteInfo{Charset: name}
+	var r io.Reader = br
+	if enc != encoding.Nop && name != "utf-8" {
+		r = transform.NewReader(br, enc.NewDecoder())
+	}
+
+	z := html.NewTokenizer(r)
+	jsonld := false
+	var script bytes.Buffer
+	var noscript strings.Builder
+	var sources []imageCandidate // of the current picture tag
 	parents := make([]atom.Atom, 0, 10)
 	hasParent := func(p atom.Atom) bool {
 		for _, a := range parents {
@@ -103,7 +136,7 @@ tokenize:
 			a := atom.Lookup(name)
 
 			switch a {
-			case atom.Meta, atom.Img, atom.Base:
+			case atom.Meta, atom.Img, atom.Source, atom.Base, atom.Link, atom.Script:
 				m := make(map[string]string)
 				var key, val []byte
 				for hasAttr {
@@ -113,22 +146,29 @@ tokenize:
 
 				switch a {
 				case atom.Meta:
-					if len(s.Description) == 0 && m["name"] == "description" {
-						m["property"] = "og:description"
-					}
-					s.ProcessMeta(m["property"], m["content"])
+					s.processMetaTag(m)
 
 				case atom.Img:
-					if !hasParent(atom.A) {
-						url := m["src"]
-						if !strings.Contains(url, "://") && !strings.HasPrefix(url, "//") {
-							url = path.Join(base, url)
-						}
-						s.Images = append(s.Images, url)
+					if m["itemprop"] == "image" || !hasParent(atom.A) {
+						s.processImg(m, sources)
+					}
+					sources = nil
+
+				case atom.Source:
+					if hasParent(atom.Picture) && !hasParent(atom.A) {
+						sources = append(sources, processPictureSource(m)...)
 					}
 
 				case atom.Base:
-					base = m["href"]
+					if len(s.base) == 0 {
+						s.base = m["href"]
+					}
+
+				case atom.Link:
+					s.processLink(m)
+
+				case atom.Script:
+					jsonld = strings.EqualFold(strings.TrimSpace(m["type"]), "application/ld+json")
 				}
 			}
 
@@ -139,6 +179,20 @@ tokenize:
 		case html.EndTagToken:
 			name, _ := z.TagName()
 			a := atom.Lookup(name)
+			if a == atom.Script && jsonld {
+				s.processJSONLD(script.Bytes())
+				script.Reset()
+				jsonld = false
+			}
+			if a == atom.Noscript {
+				if !hasParent(atom.A) {
+					s.processNoscript(noscript.String())
+				}
+				noscript.Reset()
+			}
+			if a == atom.Picture {
+				sources = nil
+			}
 			for i := len(parents) - 1; i >= 0; i-- {
 				if parents[i] == a {
 					parents = parents[:i]
@@ -147,33 +201,52 @@ tokenize:
 			}
 
 		case html.TextToken:
-			if len(s.Title) == 0 && hasParent(atom.Title) {
-				s.Title = string(z.Text())
+			if jsonld {
+				script.Write(z.Text())
+			}
+			if hasParent(atom.Noscript) {
+				noscript.Write(z.Text())
+			}
+			if hasParent(atom.Title) && !hasParent(atom.Svg) {
+				s.set(&s.Title, "title", string(z.Text()), "html:title")
 			}
 		}
 	}
 
+	s.sortImages()
 	return s, nil
 }
 
 // GetFromURL returns SiteInfo from an URL
 func GetFromURL(ctx context.Context, url string) (*SiteInfo, error) {
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		return nil, err
-	}
-	req.Header.Set("accept", "text/html")
-
-	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	resp, err := fetch.Get(ctx, url, "text/html")
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
 
-	s, err := Get(resp.Body)
+	s, err := GetWithContentType(resp.Body, resp.Header.Get("Content-Type"))
 	if s != nil && len(s.URL) == 0 {
 		s.URL = url
 	}
 
 	return s, err
 }
+
+// declaresCharset tells if the beginning of the page has a meta tag declaring its charset
+func declaresCharset(head []byte) bool {
+	return bytes.Contains(bytes.ToLower(head), []byte("charset"))
+}
+
+// validUTF8 tells if b is valid UTF-8, ignoring an incomplete rune at the end
+func validUTF8(b []byte) bool {
+	for i := len(b) - 1; i >= 0 && i > len(b)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(b[i]) {
+			if !utf8.FullRune(b[i:]) {
+				b = b[:i]
+			}
+			break
+		}
+	}
+	return utf8.Valid(b)
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/razzie/mediaserver/media"
+)
+
+// ErrNotFound is returned by stores when there is no (unexpired) media saved under a key
+var ErrNotFound = errors.New("not found")
+
+// Store is a cache for Media
+type Store interface {
+	GetMedia(key string) (*media.Media, error)
+	SetMedia(key string, m *media.Media) error
+	Delete(key string) error
+	Stats() (*StoreStats, error)
+}
+
+// Locker is implemented by stores that are shared between server instances
+// and can be used to make sure only one of them fetches the same media at a time
+type Locker interface {
+	Lock(key string, ttl time.Duration) (bool, error)
+	Unlock(key string) error
+}
+
+// StoreStats contains the basic details of a Store
+type StoreStats struct {
+	Backend string `json:"backend"`
+	Items   int64  `json:"items"`
+	Size    int64  `json:"size"`
+}
+
+// NewStore returns a Store based on the scheme of the cache URL:
+// memory://?max-items=1000&max-size=268435456, file:///path/to/dir?max-items=10000&max-size=1073741824 or redis://host:port
+func NewStore(cacheURL string, expiration time.Duration) (Store, error) {
+	u, err := url.Parse(cacheURL)
+	if err != nil {
+		return nil, err
+	}
+
+	switch u.Scheme {
+	case "memory":
+		return NewMemoryStore(u.Query(), expiration)
+	case "file":
+		if len(u.Host) > 0 || len(u.Opaque) > 0 || len(u.Path) == 0 {
+			return nil, fmt.Errorf("file cache URL must have an absolute path, like file:///path/to/dir: %s", cacheURL)
+		}
+		return NewFileStore(u.Path, u.Query(), expiration)
+	case "redis", "rediss":
+		return NewRedisStore(cacheURL, expiration)
+	default:
+		return nil, fmt.Errorf("unsupported cache scheme: %s", u.Scheme)
+	}
+}
+
+// expirationOf returns how long a Media should be kept in the cache.
+// Media without a thumbnail is only kept for a short time to avoid refetching it too often.
+func expirationOf(m *media.Media, expiration time.Duration) time.Duration {
+	if m.Thumbnail == nil {
+		return time.Minute
+	}
+	return expiration
+}
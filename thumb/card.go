@@ -0,0 +1,139 @@
+package thumb
+
+import (
+	"hash/fnv"
+	"image"
+	"image/color"
+	"image/draw"
+	"strings"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/math/fixed"
+)
+
+// Card contains the text of a generated thumbnail for pages that have no usable image
+type Card struct {
+	Title       string
+	Description string
+	Host        string
+}
+
+// GetCard renders the card to a thumbnail of exactly the size given in opts.
+// The background color is derived from the host, so every page of a site gets the same color.
+func GetCard(card Card, opts Options) (*Thumbnail, error) {
+	var t *Thumbnail
+	var err error
+	if poolErr := DefaultPool.Do(func() {
+		t, err = encode(drawCard(card, opts), opts)
+	}); poolErr != nil {
+		return nil, poolErr
+	}
+	if t != nil {
+		t.Generated = true
+	}
+	return t, err
+}
+
+func drawCard(card Card, opts Options) image.Image {
+	width, height := int(opts.Width), int(opts.Height)
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(dst, dst.Bounds(), image.NewUniform(hostColor(card.Host)), image.Point{}, draw.Src)
+
+	size := width
+	if height < width {
+		size = height
+	}
+	padding := size / 12
+	maxWidth := fixed.I(width - 2*padding)
+
+	title := strings.Join(strings.Fields(card.Title), " ")
+	if len(title) == 0 {
+		title = card.Host
+	}
+	description := strings.Join(strings.Fields(card.Description), " ")
+
+	hostFace := newLabelFace(cardFontSize(size, 6))
+	defer hostFace.Close()
+	hostLines := wrapLabel(hostFace, card.Host, maxWidth, 1)
+	bottom := height - padding
+	if len(hostLines) > 0 {
+		bottom -= hostFace.Metrics().Height.Ceil() + padding/2
+		drawLines(dst, hostFace, hostLines, padding, bottom+padding/2, color.NRGBA{0xff, 0xff, 0xff, 0xb0})
+	}
+
+	y := padding
+	titleFace := newLabelFace(cardFontSize(size, 10))
+	defer titleFace.Close()
+	y = drawLines(dst, titleFace, fitLines(titleFace, title, maxWidth, 3, bottom-y), padding, y, color.White)
+
+	y += padding / 2
+	descFace := newLabelFace(cardFontSize(size, 6))
+	defer descFace.Close()
+	drawLines(dst, descFace, fitLines(descFace, description, maxWidth, 5, bottom-y), padding, y, color.NRGBA{0xff, 0xff, 0xff, 0xdd})
+
+	return dst
+}
+
+// cardFontSize returns percent of the card size as font size
+func cardFontSize(size, percent int) float64 {
+	fontSize := float64(size*percent) / 100
+	if fontSize < minLabelFontSize {
+		return minLabelFontSize
+	}
+	return fontSize
+}
+
+// fitLines wraps the text to at most maxLines lines that fit in maxHeight
+func fitLines(face font.Face, text string, maxWidth fixed.Int26_6, maxLines, maxHeight int) []string {
+	if lines := maxHeight / face.Metrics().Height.Ceil(); lines < maxLines {
+		maxLines = lines
+	}
+	if maxLines < 1 || len(text) == 0 {
+		return nil
+	}
+	return wrapLabel(face, text, maxWidth, maxLines)
+}
+
+// drawLines draws the lines of text below each other starting at x, y and returns the y below the last line
+func drawLines(dst draw.Image, face font.Face, lines []string, x, y int, c color.Color) int {
+	metrics := face.Metrics()
+	d := font.Drawer{
+		Dst:  dst,
+		Src:  image.NewUniform(c),
+		Face: face,
+	}
+	for _, line := range lines {
+		d.Dot = fixed.P(x, y+metrics.Ascent.Ceil())
+		d.DrawString(line)
+		y += metrics.Height.Ceil()
+	}
+	return y
+}
+
+// hostColor returns a dark, saturated color derived from the hash of the host
+func hostColor(host string) color.RGBA {
+	h := fnv.New32a()
+	h.Write([]byte(strings.TrimPrefix(strings.ToLower(host), "www.")))
+	hue := float64(h.Sum32()%360) / 60
+
+	// HSV to RGB with 60% saturation and 45% value
+	const s, v = 0.6, 0.45
+	f := hue - float64(int(hue))
+	p, q, t := v*(1-s), v*(1-s*f), v*(1-s*(1-f))
+	var r, g, b float64
+	switch int(hue) {
+	case 0:
+		r, g, b = v, t, p
+	case 1:
+		r, g, b = q, v, p
+	case 2:
+		r, g, b = p, v, t
+	case 3:
+		r, g, b = p, q, v
+	case 4:
+		r, g, b = t, p, v
+	default:
+		r, g, b = v, p, q
+	}
+	return color.RGBA{uint8(r * 255), uint8(g * 255), uint8(b * 255), 0xff}
+}
@@ -0,0 +1,47 @@
+package thumb
+
+import (
+	"fmt"
+	"image/color"
+	"strconv"
+	"strings"
+)
+
+// parseColor parses a hex color in rgb, rgba, rrggbb or rrggbbaa format with an optional # prefix
+func parseColor(s string) (color.NRGBA, error) {
+	hex := strings.TrimPrefix(s, "#")
+	switch len(hex) {
+	case 3, 4:
+		var long []byte
+		for i := range hex {
+			long = append(long, hex[i], hex[i])
+		}
+		hex = string(long)
+	case 6, 8:
+	default:
+		return color.NRGBA{}, fmt.Errorf("invalid color: %s", s)
+	}
+	if len(hex) == 6 {
+		hex += "ff"
+	}
+
+	value, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		return color.NRGBA{}, fmt.Errorf("invalid color: %s", s)
+	}
+
+	return color.NRGBA{
+		R: uint8(value >> 24),
+		G: uint8(value >> 16),
+		B: uint8(value >> 8),
+		A: uint8(value),
+	}, nil
+}
+
+// formatColor returns the color in rrggbb or rrggbbaa format
+func formatColor(c color.NRGBA) string {
+	if c.A == 0xff {
+		return fmt.Sprintf("%02x%02x%02x", c.R, c.G, c.B)
+	}
+	return fmt.Sprintf("%02x%02x%02x%02x", c.R, c.G, c.B, c.A)
+}
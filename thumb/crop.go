@@ -0,0 +1,180 @@
+package thumb
+
+import (
+	"image"
+	"image/draw"
+
+	"github.com/nfnt/resize"
+)
+
+var cropModes = map[string]bool{
+	"center": true,
+	"top":    true,
+	"smart":  true,
+}
+
+// layout returns the region of the source image to be used and the size of the thumbnail.
+// Without cropping the whole image is fit in the requested size,
+// otherwise a region with the requested aspect ratio is chosen based on the crop mode.
+// Images are never enlarged.
+func layout(src image.Image, opts Options) (region image.Rectangle, width, height uint) {
+	b := src.Bounds()
+	if len(opts.Crop) == 0 {
+		width, height = fitSize(uint(b.Dx()), uint(b.Dy()), opts.Width, opts.Height)
+		return b, width, height
+	}
+
+	cropWidth, cropHeight := b.Dx(), b.Dy()
+	if cropWidth*int(opts.Height) > cropHeight*int(opts.Width) {
+		cropWidth = cropHeight * int(opts.Width) / int(opts.Height)
+	} else {
+		cropHeight = cropWidth * int(opts.Height) / int(opts.Width)
+	}
+	if cropWidth < 1 {
+		cropWidth = 1
+	}
+	if cropHeight < 1 {
+		cropHeight = 1
+	}
+
+	var offset image.Point
+	switch opts.Crop {
+	case "top":
+		offset = image.Pt((b.Dx()-cropWidth)/2, 0)
+	case "smart":
+		offset = smartOffset(src, cropWidth, cropHeight)
+	default:
+		offset = image.Pt((b.Dx()-cropWidth)/2, (b.Dy()-cropHeight)/2)
+	}
+
+	min := b.Min.Add(offset)
+	region = image.Rectangle{Min: min, Max: min.Add(image.Pt(cropWidth, cropHeight))}
+
+	width, height = opts.Width, opts.Height
+	if uint(cropWidth) < width || uint(cropHeight) < height {
+		width, height = uint(cropWidth), uint(cropHeight)
+	}
+	return region, width, height
+}
+
+// resizeRegion resizes the given region of the image to width x height.
+// The result never shares pixels with the source if it's a cropped region.
+func resizeRegion(src image.Image, region image.Rectangle, width, height uint, filter string) image.Image {
+	exact := uint(region.Dx()) == width && uint(region.Dy()) == height
+	if region == src.Bounds() {
+		if exact {
+			return src
+		}
+		return scale(src, width, height, filter)
+	}
+
+	sub, ok := src.(interface {
+		SubImage(image.Rectangle) image.Image
+	})
+	if ok && !exact {
+		return scale(sub.SubImage(region), width, height, filter)
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, region.Dx(), region.Dy()))
+	draw.Draw(dst, dst.Bounds(), src, region.Min, draw.Src)
+	if exact {
+		return dst
+	}
+	return scale(dst, width, height, filter)
+}
+
+// smartOffset returns the offset of the cropWidth x cropHeight region with the most details,
+// based on the edge energy of a downscaled grayscale version of the image
+func smartOffset(src image.Image, cropWidth, cropHeight int) image.Point {
+	const energySize = 64
+
+	b := src.Bounds()
+	w, h := fitSize(uint(b.Dx()), uint(b.Dy()), energySize, energySize)
+	small := resize.Resize(w, h, src, resize.NearestNeighbor)
+	sb := small.Bounds()
+	sw, sh := sb.Dx(), sb.Dy()
+
+	lum := make([]int, sw*sh)
+	for y := 0; y < sh; y++ {
+		for x := 0; x < sw; x++ {
+			r, g, b, _ := small.At(sb.Min.X+x, sb.Min.Y+y).RGBA()
+			lum[y*sw+x] = int(299*r+587*g+114*b) / 1000 >> 8
+		}
+	}
+
+	at := func(x, y int) int {
+		if x < 0 {
+			x = 0
+		} else if x >= sw {
+			x = sw - 1
+		}
+		if y < 0 {
+			y = 0
+		} else if y >= sh {
+			y = sh - 1
+		}
+		return lum[y*sw+x]
+	}
+
+	// sum of the edge energy of each column and row
+	cols := make([]int, sw)
+	rows := make([]int, sh)
+	for y := 0; y < sh; y++ {
+		for x := 0; x < sw; x++ {
+			e := abs(at(x+1, y)-at(x-1, y)) + abs(at(x, y+1)-at(x, y-1))
+			cols[x] += e
+			rows[y] += e
+		}
+	}
+
+	if cropWidth < b.Dx() {
+		window := cropWidth * sw / b.Dx()
+		start := bestWindow(cols, window)
+		x := start * b.Dx() / sw
+		if x > b.Dx()-cropWidth {
+			x = b.Dx() - cropWidth
+		}
+		return image.Pt(x, (b.Dy()-cropHeight)/2)
+	}
+
+	window := cropHeight * sh / b.Dy()
+	start := bestWindow(rows, window)
+	y := start * b.Dy() / sh
+	if y > b.Dy()-cropHeight {
+		y = b.Dy() - cropHeight
+	}
+	return image.Pt((b.Dx()-cropWidth)/2, y)
+}
+
+// bestWindow returns the start of the window with the highest sum,
+// preferring the one closest to the center if there are several
+func bestWindow(values []int, window int) int {
+	if window < 1 {
+		window = 1
+	}
+	if window >= len(values) {
+		return 0
+	}
+
+	sum := 0
+	for _, v := range values[:window] {
+		sum += v
+	}
+
+	center := (len(values) - window) / 2
+	best, bestSum := 0, sum
+	for start := 1; start+window <= len(values); start++ {
+		sum += values[start+window-1] - values[start-1]
+		if sum > bestSum || (sum == bestSum && abs(start-center) < abs(best-center)) {
+			best, bestSum = start, sum
+		}
+	}
+	return best
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
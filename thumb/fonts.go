@@ -0,0 +1,142 @@
+package thumb
+
+import (
+	"fmt"
+	"image"
+	"io/ioutil"
+	"strings"
+
+	"github.com/golang/freetype/truetype"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/font/sfnt"
+	"golang.org/x/image/math/fixed"
+)
+
+// Fonts are the fonts labels are drawn with, each rune with the first font that contains it.
+// The embedded Font is used for runes none of them contain.
+var Fonts []*opentype.Font
+
+// LoadFonts parses a comma separated list of TTF or OTF files and appends them to Fonts
+func LoadFonts(paths string) error {
+	for _, path := range strings.Split(paths, ",") {
+		path = strings.TrimSpace(path)
+		if len(path) == 0 {
+			continue
+		}
+
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		f, err := opentype.Parse(data)
+		if err != nil {
+			return fmt.Errorf("invalid font %s: %v", path, err)
+		}
+		Fonts = append(Fonts, f)
+	}
+	return nil
+}
+
+// newLabelFace returns a face that draws each rune with the first of Fonts that contains it,
+// falling back to the embedded Font
+func newLabelFace(size float64) font.Face {
+	face := &fallbackFace{
+		runes: make(map[rune]int),
+	}
+
+	for _, f := range Fonts {
+		otf, err := opentype.NewFace(f, &opentype.FaceOptions{
+			Size:    size,
+			DPI:     72,
+			Hinting: font.HintingFull,
+		})
+		if err != nil {
+			continue
+		}
+		f := f
+		var buf sfnt.Buffer
+		face.faces = append(face.faces, otf)
+		face.contains = append(face.contains, func(r rune) bool {
+			index, err := f.GlyphIndex(&buf, r)
+			return err == nil && index != 0
+		})
+	}
+
+	face.faces = append(face.faces, truetype.NewFace(Font, &truetype.Options{
+		Size:    size,
+		DPI:     72,
+		Hinting: font.HintingFull,
+	}))
+	face.contains = append(face.contains, func(rune) bool { return true })
+
+	return face
+}
+
+// fallbackFace is a font.Face made of several faces, each rune is drawn with the first face that contains it
+type fallbackFace struct {
+	faces    []font.Face
+	contains []func(rune) bool
+	runes    map[rune]int
+}
+
+func (f *fallbackFace) face(r rune) (int, font.Face) {
+	i, ok := f.runes[r]
+	if !ok {
+		for i = range f.faces {
+			if f.contains[i](r) {
+				break
+			}
+		}
+		f.runes[r] = i
+	}
+	return i, f.faces[i]
+}
+
+func (f *fallbackFace) Close() error {
+	for _, face := range f.faces {
+		face.Close()
+	}
+	return nil
+}
+
+func (f *fallbackFace) Glyph(dot fixed.Point26_6, r rune) (dr image.Rectangle, mask image.Image, maskp image.Point, advance fixed.Int26_6, ok bool) {
+	_, face := f.face(r)
+	return face.Glyph(dot, r)
+}
+
+func (f *fallbackFace) GlyphBounds(r rune) (bounds fixed.Rectangle26_6, advance fixed.Int26_6, ok bool) {
+	_, face := f.face(r)
+	return face.GlyphBounds(r)
+}
+
+func (f *fallbackFace) GlyphAdvance(r rune) (advance fixed.Int26_6, ok bool) {
+	_, face := f.face(r)
+	return face.GlyphAdvance(r)
+}
+
+func (f *fallbackFace) Kern(r0, r1 rune) fixed.Int26_6 {
+	i0, face := f.face(r0)
+	if i1, _ := f.face(r1); i0 != i1 {
+		return 0
+	}
+	return face.Kern(r0, r1)
+}
+
+// Metrics returns the largest metrics of the faces, so every glyph fits in a line
+func (f *fallbackFace) Metrics() font.Metrics {
+	var m font.Metrics
+	for _, face := range f.faces {
+		fm := face.Metrics()
+		if fm.Height > m.Height {
+			m.Height = fm.Height
+		}
+		if fm.Ascent > m.Ascent {
+			m.Ascent = fm.Ascent
+		}
+		if fm.Descent > m.Descent {
+			m.Descent = fm.Descent
+		}
+	}
+	return m
+}
@@ -0,0 +1,225 @@
+package thumb
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+)
+
+var (
+	// MaxFrames is the maximum number of frames an animated thumbnail can have
+	MaxFrames = 100
+	// MaxAnimationSize is the maximum size of an animated thumbnail in bytes
+	MaxAnimationSize = 2 << 20
+)
+
+// animatedThumbnail returns an animated gif thumbnail or false if the image is not an animation
+// or it exceeds the limits, in which case a still thumbnail should be made instead
+func animatedThumbnail(data []byte, config image.Config, label string, opts Options) (*Thumbnail, bool) {
+	frames, err := countGIFFrames(data)
+	if err != nil || frames < 2 || frames > MaxFrames {
+		return nil, false
+	}
+	if int64(frames)*int64(config.Width)*int64(config.Height) > int64(MaxPixels) {
+		return nil, false
+	}
+
+	g, err := gif.DecodeAll(bytes.NewReader(data))
+	if err != nil || g.Config.Width == 0 || g.Config.Height == 0 {
+		return nil, false
+	}
+
+	// frames are drawn over the previous ones as a browser would do,
+	// then the result is resized and converted back to the frame's palette
+	canvas := image.NewRGBA(image.Rect(0, 0, g.Config.Width, g.Config.Height))
+	draw.Draw(canvas, g.Image[0].Bounds(), g.Image[0], g.Image[0].Bounds().Min, draw.Src)
+
+	region, width, height := layout(canvas, opts) // based on the first frame
+	bounds := image.Rect(0, 0, int(width), int(height))
+	out := &gif.GIF{
+		LoopCount: g.LoopCount,
+		Config:    image.Config{Width: int(width), Height: int(height)},
+	}
+
+	draw.Draw(canvas, canvas.Bounds(), image.Transparent, image.Point{}, draw.Src)
+	transparent := false
+	for i, frame := range g.Image {
+		var disposal byte
+		if i < len(g.Disposal) {
+			disposal = g.Disposal[i]
+		}
+
+		var previous *image.RGBA
+		if disposal == gif.DisposalPrevious {
+			previous = image.NewRGBA(canvas.Bounds())
+			copy(previous.Pix, canvas.Pix)
+		}
+
+		draw.Draw(canvas, frame.Bounds(), frame, frame.Bounds().Min, draw.Over)
+
+		resized := resizeRegion(canvas, region, width, height, opts.Filter)
+		if resized == image.Image(canvas) {
+			resized = flatten(canvas, color.Transparent) // copy, so the label is not drawn on the canvas
+		}
+		resized = drawLabel(resized, label, opts)
+
+		paletted := quantize(resized, frame.Palette)
+		transparent = transparent || !paletted.Opaque()
+
+		out.Image = append(out.Image, paletted)
+		out.Delay = append(out.Delay, g.Delay[i])
+		out.Disposal = append(out.Disposal, disposal)
+
+		switch disposal {
+		case gif.DisposalBackground:
+			draw.Draw(canvas, frame.Bounds(), image.Transparent, image.Point{}, draw.Src)
+		case gif.DisposalPrevious:
+			canvas = previous
+		}
+	}
+
+	// every frame is a full frame now, so the transparent parts have to be cleared between them
+	if transparent {
+		for i := range out.Disposal {
+			out.Disposal[i] = gif.DisposalBackground
+		}
+	}
+
+	var result bytes.Buffer
+	if err := gif.EncodeAll(&result, out); err != nil || result.Len() > MaxAnimationSize {
+		return nil, false
+	}
+
+	return &Thumbnail{
+		Data:   result.Bytes(),
+		MIME:   "image/gif",
+		Bounds: bounds,
+	}, true
+}
+
+// quantize converts the image to a paletted one using the given palette.
+// Pixels that are more than half transparent become fully transparent.
+func quantize(img image.Image, palette color.Palette) *image.Paletted {
+	palette = append(color.Palette(nil), palette...)
+	transparentIndex := -1
+	for i, c := range palette {
+		if _, _, _, a := c.RGBA(); a == 0 {
+			transparentIndex = i
+			break
+		}
+	}
+
+	b := img.Bounds()
+	dst := image.NewPaletted(image.Rect(0, 0, b.Dx(), b.Dy()), palette)
+	if transparentIndex == -1 && !isOpaque(img) {
+		transparentIndex = addTransparent(dst)
+	}
+
+	var cache [1 << 15]int16 // nearest palette index + 1 for colors reduced to 5 bits per channel
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			r, g, bl, a := img.At(x, y).RGBA()
+			if a < 0x8000 {
+				dst.Pix[dst.PixOffset(x-b.Min.X, y-b.Min.Y)] = uint8(transparentIndex)
+				continue
+			}
+
+			if a < 0xffff {
+				r, g, bl = r*0xffff/a, g*0xffff/a, bl*0xffff/a
+			}
+
+			key := (r>>11)<<10 | (g>>11)<<5 | bl>>11
+			if cache[key] == 0 {
+				cache[key] = int16(nearestOpaque(dst.Palette, r, g, bl)) + 1
+			}
+			dst.Pix[dst.PixOffset(x-b.Min.X, y-b.Min.Y)] = uint8(cache[key] - 1)
+		}
+	}
+
+	return dst
+}
+
+// addTransparent adds a transparent color to the palette (replacing the last one if it's full)
+func addTransparent(img *image.Paletted) int {
+	if len(img.Palette) < 256 {
+		img.Palette = append(img.Palette, color.Transparent)
+	} else {
+		img.Palette[255] = color.Transparent
+	}
+	return len(img.Palette) - 1
+}
+
+func nearestOpaque(palette color.Palette, r, g, b uint32) int {
+	best, bestDist := 0, uint64(1<<63)
+	for i, c := range palette {
+		pr, pg, pb, pa := c.RGBA()
+		if pa == 0 {
+			continue
+		}
+		dr, dg, db := int64(pr)-int64(r), int64(pg)-int64(g), int64(pb)-int64(b)
+		if dist := uint64(dr*dr + dg*dg + db*db); dist < bestDist {
+			best, bestDist = i, dist
+		}
+	}
+	return best
+}
+
+var errInvalidGIF = errors.New("invalid gif")
+
+// countGIFFrames returns the number of frames in a gif without decoding them
+func countGIFFrames(data []byte) (int, error) {
+	if len(data) < 13 || string(data[:3]) != "GIF" {
+		return 0, errInvalidGIF
+	}
+
+	pos := 13
+	if flags := data[10]; flags&0x80 != 0 {
+		pos += 3 << (flags&0x07 + 1) // global color table
+	}
+
+	skipSubBlocks := func() error {
+		for {
+			if pos >= len(data) {
+				return errInvalidGIF
+			}
+			size := int(data[pos])
+			pos += size + 1
+			if size == 0 {
+				return nil
+			}
+		}
+	}
+
+	frames := 0
+	for pos < len(data) {
+		switch data[pos] {
+		case 0x21: // extension
+			pos += 2
+			if err := skipSubBlocks(); err != nil {
+				return frames, err
+			}
+		case 0x2c: // image descriptor
+			if pos+10 > len(data) {
+				return frames, errInvalidGIF
+			}
+			frames++
+			flags := data[pos+9]
+			pos += 10
+			if flags&0x80 != 0 {
+				pos += 3 << (flags&0x07 + 1) // local color table
+			}
+			pos++ // LZW minimum code size
+			if err := skipSubBlocks(); err != nil {
+				return frames, err
+			}
+		case 0x3b: // trailer
+			return frames, nil
+		default:
+			return frames, errInvalidGIF
+		}
+	}
+	return frames, nil
+}
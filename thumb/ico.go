@@ -0,0 +1,220 @@
+package thumb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"io/ioutil"
+)
+
+var errInvalidICO = errors.New("invalid ico")
+
+// icoEntry is an image in an ico file
+type icoEntry struct {
+	width, height int
+	bpp           int
+	data          []byte
+}
+
+// decodeICO decodes the largest image of an ico file
+func decodeICO(r io.Reader) (image.Image, error) {
+	entry, err := largestICOEntry(r)
+	if err != nil {
+		return nil, err
+	}
+	if isPNG(entry.data) {
+		return png.Decode(bytes.NewReader(entry.data))
+	}
+	return decodeDIB(entry.data)
+}
+
+// decodeICOConfig returns the dimensions of the largest image of an ico file
+func decodeICOConfig(r io.Reader) (image.Config, error) {
+	entry, err := largestICOEntry(r)
+	if err != nil {
+		return image.Config{}, err
+	}
+	if isPNG(entry.data) {
+		return png.DecodeConfig(bytes.NewReader(entry.data))
+	}
+	header, err := parseDIBHeader(entry.data)
+	if err != nil {
+		return image.Config{}, err
+	}
+	return image.Config{
+		ColorModel: color.NRGBAModel,
+		Width:      header.width,
+		Height:     header.height,
+	}, nil
+}
+
+func largestICOEntry(r io.Reader) (*icoEntry, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < 6 || binary.LittleEndian.Uint16(data[2:]) != 1 {
+		return nil, errInvalidICO
+	}
+
+	var best *icoEntry
+	count := int(binary.LittleEndian.Uint16(data[4:]))
+	for i := 0; i < count; i++ {
+		if 6+i*16+16 > len(data) {
+			return nil, errInvalidICO
+		}
+		dir := data[6+i*16:]
+
+		entry := &icoEntry{
+			width:  int(dir[0]),
+			height: int(dir[1]),
+			bpp:    int(binary.LittleEndian.Uint16(dir[6:])),
+		}
+		if entry.width == 0 {
+			entry.width = 256
+		}
+		if entry.height == 0 {
+			entry.height = 256
+		}
+
+		size := int64(binary.LittleEndian.Uint32(dir[8:]))
+		offset := int64(binary.LittleEndian.Uint32(dir[12:]))
+		if offset+size > int64(len(data)) {
+			continue
+		}
+		entry.data = data[offset : offset+size]
+
+		if best == nil || entry.width*entry.height > best.width*best.height ||
+			(entry.width*entry.height == best.width*best.height && entry.bpp > best.bpp) {
+			best = entry
+		}
+	}
+
+	if best == nil {
+		return nil, errInvalidICO
+	}
+	return best, nil
+}
+
+func isPNG(data []byte) bool {
+	return len(data) >= 8 && string(data[:8]) == "\x89PNG\r\n\x1a\n"
+}
+
+type dibHeader struct {
+	size          int
+	width, height int
+	bpp           int
+	colors        int
+}
+
+// parseDIBHeader parses the BITMAPINFOHEADER of an ico image,
+// height being half of the stored height as it includes the transparency mask
+func parseDIBHeader(data []byte) (*dibHeader, error) {
+	if len(data) < 40 {
+		return nil, errInvalidICO
+	}
+
+	h := &dibHeader{
+		size:   int(binary.LittleEndian.Uint32(data)),
+		width:  int(int32(binary.LittleEndian.Uint32(data[4:]))),
+		height: int(int32(binary.LittleEndian.Uint32(data[8:]))) / 2,
+		bpp:    int(binary.LittleEndian.Uint16(data[14:])),
+		colors: int(binary.LittleEndian.Uint32(data[32:])),
+	}
+	compression := binary.LittleEndian.Uint32(data[16:])
+
+	if h.size < 40 || h.width <= 0 || h.height <= 0 || h.width > 1024 || h.height > 1024 {
+		return nil, errInvalidICO
+	}
+	if compression != 0 && !(compression == 3 && h.bpp == 32) { // BI_RGB or BI_BITFIELDS (assuming BGRA)
+		return nil, errInvalidICO
+	}
+	switch h.bpp {
+	case 1, 4, 8:
+		if h.colors == 0 || h.colors > 1<<uint(h.bpp) {
+			h.colors = 1 << uint(h.bpp)
+		}
+	case 24, 32:
+		h.colors = 0
+	default:
+		return nil, errInvalidICO
+	}
+	return h, nil
+}
+
+// decodeDIB decodes a bottom-up device independent bitmap of an ico file, followed by its 1-bit transparency mask
+func decodeDIB(data []byte) (image.Image, error) {
+	h, err := parseDIBHeader(data)
+	if err != nil {
+		return nil, err
+	}
+
+	pos := h.size
+	if h.size == 40 && binary.LittleEndian.Uint32(data[16:]) == 3 {
+		pos += 12 // color masks of BI_BITFIELDS
+	}
+
+	palette := make([]color.NRGBA, h.colors)
+	for i := range palette {
+		if pos+4 > len(data) {
+			return nil, errInvalidICO
+		}
+		palette[i] = color.NRGBA{R: data[pos+2], G: data[pos+1], B: data[pos], A: 0xff}
+		pos += 4
+	}
+
+	stride := (h.width*h.bpp + 31) / 32 * 4
+	maskStride := (h.width + 31) / 32 * 4
+	if pos+stride*h.height > len(data) {
+		return nil, errInvalidICO
+	}
+	pixels := data[pos : pos+stride*h.height]
+	var mask []byte
+	if end := pos + stride*h.height + maskStride*h.height; end <= len(data) {
+		mask = data[pos+stride*h.height : end]
+	}
+
+	img := image.NewNRGBA(image.Rect(0, 0, h.width, h.height))
+	hasAlpha := false
+	for y := 0; y < h.height; y++ {
+		row := pixels[(h.height-1-y)*stride:]
+		for x := 0; x < h.width; x++ {
+			var c color.NRGBA
+			switch h.bpp {
+			case 1, 4, 8:
+				bit := x * h.bpp
+				index := int(row[bit/8]>>uint(8-h.bpp-bit%8)) & (1<<uint(h.bpp) - 1)
+				if index < len(palette) {
+					c = palette[index]
+				}
+			case 24:
+				c = color.NRGBA{R: row[x*3+2], G: row[x*3+1], B: row[x*3], A: 0xff}
+			case 32:
+				c = color.NRGBA{R: row[x*4+2], G: row[x*4+1], B: row[x*4], A: row[x*4+3]}
+				hasAlpha = hasAlpha || c.A != 0
+			}
+			img.SetNRGBA(x, y, c)
+		}
+	}
+
+	// 32-bit images have an alpha channel, the rest (and old 32-bit icons with zero alpha) use the mask
+	if h.bpp == 32 && hasAlpha {
+		return img, nil
+	}
+	for y := 0; y < h.height; y++ {
+		for x := 0; x < h.width; x++ {
+			transparent := mask != nil && mask[(h.height-1-y)*maskStride+x/8]&(0x80>>uint(x%8)) != 0
+			i := img.PixOffset(x, y)
+			if transparent {
+				img.Pix[i+3] = 0
+			} else {
+				img.Pix[i+3] = 0xff
+			}
+		}
+	}
+	return img, nil
+}
@@ -0,0 +1,166 @@
+package thumb
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/math/fixed"
+)
+
+// MaxLabelLines is the number of lines long labels are wrapped to before they get truncated
+var MaxLabelLines = 1
+
+const (
+	minLabelFontSize = 8
+	minLabelSpace    = 24
+)
+
+var labelPositions = map[string]bool{
+	"top-left":      true,
+	"top-center":    true,
+	"top-right":     true,
+	"bottom-left":   true,
+	"bottom-center": true,
+	"bottom-right":  true,
+}
+
+// drawLabel draws the label on a bar at the position given in opts if the image is large enough
+func drawLabel(img image.Image, label string, opts Options) image.Image {
+	label = strings.Join(strings.Fields(label), " ")
+	if len(label) == 0 || opts.LabelSource == "none" {
+		return img
+	}
+
+	b := img.Bounds()
+	size := float64(b.Dy()) * float64(opts.LabelSize) / 100
+	if b.Dx() < b.Dy() {
+		size = float64(b.Dx()) * float64(opts.LabelSize) / 100
+	}
+	if size < minLabelFontSize {
+		size = minLabelFontSize
+	}
+	padding := int(size / 2)
+	if b.Dx() <= minLabelSpace+2*padding || b.Dy() <= minLabelSpace+2*padding {
+		return img
+	}
+
+	textColor, err := parseColor(opts.LabelColor)
+	if err != nil {
+		textColor = color.NRGBA{0xff, 0xff, 0xff, 0xff}
+	}
+	barColor, err := parseColor(opts.LabelBackground)
+	if err != nil {
+		barColor = color.NRGBA{A: 0x99}
+	}
+
+	face := newLabelFace(size)
+	defer face.Close()
+
+	lines := wrapLabel(face, label, fixed.I(b.Dx()-2*padding), MaxLabelLines)
+	metrics := face.Metrics()
+	lineHeight := metrics.Height.Ceil()
+	barHeight := len(lines)*lineHeight + padding
+
+	dst := toDrawImage(img)
+	b = dst.Bounds()
+	vertical, horizontal := splitPosition(opts.LabelPosition)
+	bar := image.Rect(b.Min.X, b.Max.Y-barHeight, b.Max.X, b.Max.Y)
+	if vertical == "top" {
+		bar = image.Rect(b.Min.X, b.Min.Y, b.Max.X, b.Min.Y+barHeight)
+	}
+	draw.Draw(dst, bar, image.NewUniform(barColor), image.Point{}, draw.Over)
+
+	d := font.Drawer{
+		Dst:  dst,
+		Src:  image.NewUniform(textColor),
+		Face: face,
+	}
+	for i, line := range lines {
+		x := b.Min.X + padding
+		switch horizontal {
+		case "center":
+			x = b.Min.X + (b.Dx()-font.MeasureString(face, line).Ceil())/2
+		case "right":
+			x = b.Max.X - padding - font.MeasureString(face, line).Ceil()
+		}
+		y := bar.Min.Y + padding/2 + i*lineHeight + metrics.Ascent.Ceil()
+		d.Dot = fixed.P(x, y)
+		d.DrawString(line)
+	}
+
+	return dst
+}
+
+// splitPosition splits a label position like bottom-left to its vertical and horizontal parts
+func splitPosition(position string) (vertical, horizontal string) {
+	if index := strings.IndexByte(position, '-'); index != -1 {
+		return position[:index], position[index+1:]
+	}
+	return position, ""
+}
+
+// wrapLabel breaks the label into lines that fit in maxWidth, preferably at spaces.
+// The last line is truncated with an ellipsis if the label doesn't fit in maxLines.
+func wrapLabel(face font.Face, label string, maxWidth fixed.Int26_6, maxLines int) []string {
+	if maxLines < 1 {
+		maxLines = 1
+	}
+
+	var lines []string
+	for len(label) > 0 {
+		if len(lines) == maxLines-1 {
+			return append(lines, truncateLabel(face, label, maxWidth))
+		}
+
+		n := fitLabel(face, label, maxWidth)
+		if n == len(label) {
+			return append(lines, label)
+		}
+		if space := strings.LastIndexByte(label[:n+1], ' '); space > 0 {
+			n = space
+		} else if n == 0 {
+			_, n = utf8.DecodeRuneInString(label)
+		}
+
+		lines = append(lines, strings.TrimSpace(label[:n]))
+		label = strings.TrimSpace(label[n:])
+	}
+	return lines
+}
+
+// truncateLabel cuts the label on a rune boundary and appends an ellipsis if it doesn't fit in maxWidth
+func truncateLabel(face font.Face, label string, maxWidth fixed.Int26_6) string {
+	if fitLabel(face, label, maxWidth) == len(label) {
+		return label
+	}
+
+	ellipsis := "…"
+	if _, ok := face.GlyphAdvance('…'); !ok {
+		ellipsis = "..."
+	}
+
+	n := fitLabel(face, label, maxWidth-font.MeasureString(face, ellipsis))
+	return strings.TrimSpace(label[:n]) + ellipsis
+}
+
+// fitLabel returns the length in bytes of the longest prefix of the label that fits in maxWidth
+func fitLabel(face font.Face, label string, maxWidth fixed.Int26_6) int {
+	var width fixed.Int26_6
+	prev := rune(-1)
+	for i, r := range label {
+		if prev >= 0 {
+			width += face.Kern(prev, r)
+		}
+		advance, _ := face.GlyphAdvance(r)
+		width += advance
+		if width > maxWidth {
+			return i
+		}
+		prev = r
+	}
+	return len(label)
+}
@@ -0,0 +1,284 @@
+package thumb
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// MaxSize is the largest thumbnail width or height a request can ask for
+var MaxSize uint = 2048
+
+// Options control the size, quality and format of a thumbnail
+type Options struct {
+	Width      uint   // max width of the thumbnail
+	Height     uint   // max height of the thumbnail
+	Quality    int    // jpeg quality (1-100)
+	Format     string // auto, jpeg, png, gif or webp (auto means png for transparent images, jpeg otherwise)
+	Filter     string // resampling filter: nearest, bilinear, bicubic, mitchell, lanczos2 or lanczos3
+	Background string // hex color transparent images are flattened to (optional)
+	Animated   bool   // keep gif animations (if the format is auto or gif)
+	Crop       string // fill the exact size by cropping around an anchor: center, top or smart (optional)
+
+	LabelSource     string // text of the label on website thumbnails: title, site, host or none
+	LabelPosition   string // top or bottom and left, center or right, e.g. bottom-left
+	LabelSize       int    // font size of the label in percent of the thumbnail size (1-50)
+	LabelColor      string // hex color of the label text
+	LabelBackground string // hex color of the bar behind the label text
+}
+
+// DefaultOptions returns the options used when a request doesn't specify any
+func DefaultOptions() Options {
+	return Options{
+		Width:   256,
+		Height:  256,
+		Quality: 90,
+		Format:  "auto",
+		Filter:  "lanczos3",
+
+		LabelSource:     "title",
+		LabelPosition:   "bottom-left",
+		LabelSize:       7,
+		LabelColor:      "ffffff",
+		LabelBackground: "00000099",
+	}
+}
+
+// OptionKeys are the parameters understood by ParseQuery
+var OptionKeys = []string{"w", "h", "q", "fmt", "filter", "bg", "anim", "crop",
+	"label", "label-pos", "label-size", "label-color", "label-bg"}
+
+// ParseQuery overrides the options with the values found in the parameters (see OptionKeys)
+func (o Options) ParseQuery(query url.Values) (Options, error) {
+	if w := query.Get("w"); len(w) > 0 {
+		width, err := parseSize(w)
+		if err != nil {
+			return o, fmt.Errorf("invalid width: %s", w)
+		}
+		o.Width = width
+		if len(query.Get("h")) == 0 {
+			o.Height = width
+		}
+	}
+
+	if h := query.Get("h"); len(h) > 0 {
+		height, err := parseSize(h)
+		if err != nil {
+			return o, fmt.Errorf("invalid height: %s", h)
+		}
+		o.Height = height
+		if len(query.Get("w")) == 0 {
+			o.Width = height
+		}
+	}
+
+	if q := query.Get("q"); len(q) > 0 {
+		quality, err := strconv.Atoi(q)
+		if err != nil || quality < 1 || quality > 100 {
+			return o, fmt.Errorf("invalid quality: %s", q)
+		}
+		o.Quality = quality
+	}
+
+	if f := query.Get("fmt"); len(f) > 0 {
+		format := strings.ToLower(f)
+		if format == "jpg" {
+			format = "jpeg"
+		}
+		if !isSupportedFormat(format) {
+			return o, fmt.Errorf("unsupported format: %s", f)
+		}
+		o.Format = format
+	}
+
+	if f := query.Get("filter"); len(f) > 0 {
+		if _, ok := filters[f]; !ok {
+			return o, fmt.Errorf("unsupported filter: %s", f)
+		}
+		o.Filter = f
+	}
+
+	if bg := query.Get("bg"); len(bg) > 0 {
+		c, err := parseColor(bg)
+		if err != nil {
+			return o, err
+		}
+		o.Background = formatColor(c)
+	}
+
+	if anim := query.Get("anim"); len(anim) > 0 {
+		animated, err := strconv.ParseBool(anim)
+		if err != nil {
+			return o, fmt.Errorf("invalid anim: %s", anim)
+		}
+		o.Animated = animated
+	}
+
+	if crop := query.Get("crop"); len(crop) > 0 {
+		if crop == "none" {
+			crop = ""
+		} else if !cropModes[crop] {
+			return o, fmt.Errorf("unsupported crop mode: %s", crop)
+		}
+		o.Crop = crop
+	}
+
+	if label := query.Get("label"); len(label) > 0 {
+		if !isSupportedLabelSource(label) {
+			return o, fmt.Errorf("unsupported label: %s", label)
+		}
+		o.LabelSource = label
+	}
+
+	if pos := query.Get("label-pos"); len(pos) > 0 {
+		if !labelPositions[pos] {
+			return o, fmt.Errorf("unsupported label position: %s", pos)
+		}
+		o.LabelPosition = pos
+	}
+
+	if size := query.Get("label-size"); len(size) > 0 {
+		labelSize, err := strconv.Atoi(size)
+		if err != nil || labelSize < 1 || labelSize > 50 {
+			return o, fmt.Errorf("invalid label size: %s", size)
+		}
+		o.LabelSize = labelSize
+	}
+
+	if c := query.Get("label-color"); len(c) > 0 {
+		labelColor, err := parseColor(c)
+		if err != nil {
+			return o, err
+		}
+		o.LabelColor = formatColor(labelColor)
+	}
+
+	if bg := query.Get("label-bg"); len(bg) > 0 {
+		labelBackground, err := parseColor(bg)
+		if err != nil {
+			return o, err
+		}
+		o.LabelBackground = formatColor(labelBackground)
+	}
+
+	return o, nil
+}
+
+// Validate returns an error if any of the options is invalid
+func (o Options) Validate() error {
+	if o.Width == 0 || o.Height == 0 || o.Width > MaxSize || o.Height > MaxSize {
+		return fmt.Errorf("size out of range: %dx%d", o.Width, o.Height)
+	}
+	if o.Quality < 1 || o.Quality > 100 {
+		return fmt.Errorf("invalid quality: %d", o.Quality)
+	}
+	if !isSupportedFormat(o.Format) {
+		return fmt.Errorf("unsupported format: %s", o.Format)
+	}
+	if _, ok := filters[o.Filter]; !ok {
+		return fmt.Errorf("unsupported filter: %s", o.Filter)
+	}
+	if len(o.Background) > 0 {
+		if _, err := parseColor(o.Background); err != nil {
+			return err
+		}
+	}
+	if len(o.Crop) > 0 && !cropModes[o.Crop] {
+		return fmt.Errorf("unsupported crop mode: %s", o.Crop)
+	}
+	if !isSupportedLabelSource(o.LabelSource) {
+		return fmt.Errorf("unsupported label: %s", o.LabelSource)
+	}
+	if !labelPositions[o.LabelPosition] {
+		return fmt.Errorf("unsupported label position: %s", o.LabelPosition)
+	}
+	if o.LabelSize < 1 || o.LabelSize > 50 {
+		return fmt.Errorf("invalid label size: %d", o.LabelSize)
+	}
+	if _, err := parseColor(o.LabelColor); err != nil {
+		return err
+	}
+	if _, err := parseColor(o.LabelBackground); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Query returns the parameters that reproduce these options
+func (o Options) Query() url.Values {
+	query := make(url.Values)
+	query.Set("w", strconv.FormatUint(uint64(o.Width), 10))
+	query.Set("h", strconv.FormatUint(uint64(o.Height), 10))
+	query.Set("q", strconv.Itoa(o.Quality))
+	query.Set("fmt", o.Format)
+	query.Set("filter", o.Filter)
+	if len(o.Background) > 0 {
+		query.Set("bg", o.Background)
+	}
+	if o.Animated {
+		query.Set("anim", "1")
+	}
+	if len(o.Crop) > 0 {
+		query.Set("crop", o.Crop)
+	} else {
+		query.Set("crop", "none") // so a crop mode set by the server's default doesn't apply
+	}
+	query.Set("label", o.LabelSource)
+	if o.LabelSource != "none" {
+		query.Set("label-pos", o.LabelPosition)
+		query.Set("label-size", strconv.Itoa(o.LabelSize))
+		query.Set("label-color", o.LabelColor)
+		query.Set("label-bg", o.LabelBackground)
+	}
+	return query
+}
+
+// Key returns a short string that identifies the thumbnail variant
+func (o Options) Key() string {
+	key := fmt.Sprintf("%dx%d-q%d-%s", o.Width, o.Height, o.Quality, o.Filter)
+	if len(o.Background) > 0 {
+		key += "-bg" + o.Background
+	}
+	if o.Animated {
+		key += "-anim"
+	}
+	if len(o.Crop) > 0 {
+		key += "-crop" + o.Crop
+	}
+	if o.LabelSource == "none" {
+		key += "-nolabel"
+	} else {
+		key += fmt.Sprintf("-%s-%s-%d-%s-%s", o.LabelSource, o.LabelPosition, o.LabelSize, o.LabelColor, o.LabelBackground)
+	}
+	return key + "." + o.Format
+}
+
+func parseSize(s string) (uint, error) {
+	size, err := strconv.ParseUint(s, 10, 32)
+	if err != nil {
+		return 0, err
+	}
+	if size == 0 || uint(size) > MaxSize {
+		return 0, fmt.Errorf("size out of range: %d", size)
+	}
+	return uint(size), nil
+}
+
+func isSupportedFormat(format string) bool {
+	switch format {
+	case "auto", "jpeg", "png", "gif", "webp":
+		return true
+	default:
+		return false
+	}
+}
+
+func isSupportedLabelSource(source string) bool {
+	switch source {
+	case "title", "site", "host", "none":
+		return true
+	default:
+		return false
+	}
+}
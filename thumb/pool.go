@@ -0,0 +1,99 @@
+package thumb
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// ErrBusy is returned when the queue of images waiting to be processed is full
+var ErrBusy = errors.New("too many images are being processed, try again later")
+
+// DefaultPool is the Pool used by Get. Its workers aren't started until it's used, so it can be replaced freely.
+var DefaultPool = NewPool(runtime.NumCPU(), 64)
+
+// Pool limits the number of images that are decoded and resized at the same time
+type Pool struct {
+	jobs    chan func()
+	workers int
+	start   sync.Once
+	queued  int32
+	active  int32
+}
+
+// PoolStats contains the size and current load of a Pool
+type PoolStats struct {
+	Workers   int `json:"workers"`
+	QueueSize int `json:"queue_size"`
+	Queued    int `json:"queued"`
+	Active    int `json:"active"`
+}
+
+// NewPool returns a new Pool with the given number of workers and queue size.
+// The workers are started by the first call of Do.
+func NewPool(workers, queueSize int) *Pool {
+	if workers < 1 {
+		workers = 1
+	}
+	if queueSize < 0 {
+		queueSize = 0
+	}
+
+	return &Pool{
+		jobs:    make(chan func(), queueSize),
+		workers: workers,
+	}
+}
+
+// Do runs fn on one of the workers and waits for it to finish.
+// It returns ErrBusy without running fn if the queue is full, and an error if fn panics.
+func (p *Pool) Do(fn func()) error {
+	p.start.Do(func() {
+		for i := 0; i < p.workers; i++ {
+			go p.work()
+		}
+	})
+
+	done := make(chan struct{})
+	var panicked interface{}
+	job := func() {
+		defer close(done)
+		defer func() { panicked = recover() }()
+		fn()
+	}
+
+	atomic.AddInt32(&p.queued, 1)
+	select {
+	case p.jobs <- job:
+	default:
+		atomic.AddInt32(&p.queued, -1)
+		return ErrBusy
+	}
+
+	<-done
+	if panicked != nil {
+		return fmt.Errorf("panic while processing image: %v", panicked)
+	}
+	return nil
+}
+
+// Stats returns the size and current load of the pool
+func (p *Pool) Stats() PoolStats {
+	return PoolStats{
+		Workers:   p.workers,
+		QueueSize: cap(p.jobs),
+		Queued:    int(atomic.LoadInt32(&p.queued)),
+		Active:    int(atomic.LoadInt32(&p.active)),
+	}
+}
+
+func (p *Pool) work() {
+	for job := range p.jobs {
+		atomic.AddInt32(&p.queued, -1)
+		atomic.AddInt32(&p.active, 1)
+		job()
+		atomic.AddInt32(&p.active, -1)
+	}
+}
@@ -0,0 +1,15 @@
+package thumb
+
+import "testing"
+
+func TestPoolPanic(t *testing.T) {
+	p := NewPool(1, 1)
+	if err := p.Do(func() { panic("broken image") }); err == nil {
+		t.Fatal("expected the panic to be returned as an error")
+	}
+
+	ran := false
+	if err := p.Do(func() { ran = true }); err != nil || !ran {
+		t.Fatalf("pool unusable after a panic: ran=%v, err=%v", ran, err)
+	}
+}
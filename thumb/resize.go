@@ -0,0 +1,59 @@
+package thumb
+
+import (
+	"image"
+
+	"github.com/nfnt/resize"
+)
+
+var filters = map[string]resize.InterpolationFunction{
+	"nearest":  resize.NearestNeighbor,
+	"bilinear": resize.Bilinear,
+	"bicubic":  resize.Bicubic,
+	"mitchell": resize.MitchellNetravali,
+	"lanczos2": resize.Lanczos2,
+	"lanczos3": resize.Lanczos3,
+}
+
+// preshrinkFactor is how many times larger the source image has to be than the thumbnail
+// to get shrunk with the cheaper bilinear filter before applying the real one.
+// When downscaling, the bilinear filter averages all source pixels it covers,
+// so thin lines and text don't alias like with nearest neighbor sampling.
+const preshrinkFactor = 4
+
+// fitSize returns the largest size that keeps the aspect ratio and fits in maxWidth x maxHeight
+// (images are never enlarged)
+func fitSize(width, height, maxWidth, maxHeight uint) (uint, uint) {
+	if width <= maxWidth && height <= maxHeight {
+		return width, height
+	}
+
+	newWidth, newHeight := maxWidth, height*maxWidth/width
+	if newHeight > maxHeight {
+		newWidth, newHeight = width*maxHeight/height, maxHeight
+	}
+	if newWidth < 1 {
+		newWidth = 1
+	}
+	if newHeight < 1 {
+		newHeight = 1
+	}
+	return newWidth, newHeight
+}
+
+// scale resizes the image to exactly width x height using the given filter.
+// Very large images are pre-shrunk with a cheap filter to save CPU.
+func scale(src image.Image, width, height uint, filter string) image.Image {
+	interp, ok := filters[filter]
+	if !ok {
+		interp = resize.Lanczos3
+	}
+
+	b := src.Bounds()
+	if interp != resize.NearestNeighbor &&
+		uint(b.Dx()) > width*preshrinkFactor && uint(b.Dy()) > height*preshrinkFactor {
+		src = resize.Resize(width*2, height*2, src, resize.Bilinear)
+	}
+
+	return resize.Resize(width, height, src, interp)
+}
@@ -0,0 +1,895 @@
+package thumb
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"image"
+	"image/color"
+	"math"
+	"strconv"
+	"strings"
+
+	"golang.org/x/image/vector"
+)
+
+// MaxSVGElements is the maximum number of shapes drawn of an SVG image
+var MaxSVGElements = 5000
+
+var errInvalidSVG = errors.New("invalid svg")
+
+// isSVG returns true if the data looks like an SVG document
+func isSVG(data []byte) bool {
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 || data[0] != '<' {
+		return false
+	}
+	if len(data) > 4096 {
+		data = data[:4096]
+	}
+	return bytes.Contains(data, []byte("<svg"))
+}
+
+// svgNode is an element of an SVG document
+type svgNode struct {
+	XMLName  xml.Name
+	Attrs    []xml.Attr `xml:",any,attr"`
+	Children []svgNode  `xml:",any"`
+}
+
+func (n *svgNode) attr(name string) string {
+	for _, a := range n.Attrs {
+		if a.Name.Local == name {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+// svgStyle contains the inherited presentation attributes
+type svgStyle struct {
+	transform     affine
+	fill          color.NRGBA
+	stroke        color.NRGBA
+	strokeWidth   float64
+	fillOpacity   float64
+	strokeOpacity float64
+	opacity       float64
+	color         color.NRGBA
+}
+
+// rasterizeSVG draws a simple SVG image to fit in the thumbnail size of opts (or to cover it if the image is cropped).
+// Shapes and paths are supported with solid fills and strokes, while gradients are replaced by their first color.
+func rasterizeSVG(data []byte, opts Options) (image.Image, error) {
+	var root svgNode
+	if err := xml.Unmarshal(data, &root); err != nil {
+		return nil, err
+	}
+	if root.XMLName.Local != "svg" {
+		return nil, errInvalidSVG
+	}
+
+	viewBox := parseNumbers(root.attr("viewBox"))
+	width, height := root.length("width"), root.length("height")
+	if len(viewBox) == 4 && viewBox[2] > 0 && viewBox[3] > 0 {
+		if width <= 0 && height <= 0 {
+			width, height = viewBox[2], viewBox[3]
+		} else if width <= 0 {
+			width = height * viewBox[2] / viewBox[3]
+		} else if height <= 0 {
+			height = width * viewBox[3] / viewBox[2]
+		}
+	} else {
+		if width <= 0 {
+			width = 300
+		}
+		if height <= 0 {
+			height = 150
+		}
+		viewBox = []float64{0, 0, width, height}
+	}
+
+	scale := math.Min(float64(opts.Width)/width, float64(opts.Height)/height)
+	if len(opts.Crop) > 0 {
+		scale = math.Max(float64(opts.Width)/width, float64(opts.Height)/height)
+	}
+	outWidth, outHeight := int(math.Round(width*scale)), int(math.Round(height*scale))
+	if outWidth < 1 || outHeight < 1 || outWidth > int(MaxSize)*4 || outHeight > int(MaxSize)*4 {
+		return nil, errInvalidSVG
+	}
+
+	// viewBox to viewport with preserveAspectRatio="xMidYMid meet"
+	vbScale := math.Min(float64(outWidth)/viewBox[2], float64(outHeight)/viewBox[3])
+	tx := (float64(outWidth)-viewBox[2]*vbScale)/2 - viewBox[0]*vbScale
+	ty := (float64(outHeight)-viewBox[3]*vbScale)/2 - viewBox[1]*vbScale
+
+	r := &svgRenderer{
+		dst:       image.NewRGBA(image.Rect(0, 0, outWidth, outHeight)),
+		gradients: make(map[string]color.NRGBA),
+	}
+	r.collectGradients(&root)
+
+	black := color.NRGBA{A: 0xff}
+	r.render(&root, svgStyle{
+		transform:     affine{vbScale, 0, 0, vbScale, tx, ty},
+		fill:          black,
+		strokeWidth:   1,
+		fillOpacity:   1,
+		strokeOpacity: 1,
+		opacity:       1,
+		color:         black,
+	})
+	return r.dst, nil
+}
+
+type svgRenderer struct {
+	dst       *image.RGBA
+	raster    vector.Rasterizer
+	polygons  [][]point
+	gradients map[string]color.NRGBA
+	elements  int
+}
+
+// collectGradients stores the first stop color of each gradient to be used as a solid color
+func (r *svgRenderer) collectGradients(n *svgNode) {
+	switch n.XMLName.Local {
+	case "linearGradient", "radialGradient":
+		for i := range n.Children {
+			stop := &n.Children[i]
+			if stop.XMLName.Local != "stop" {
+				continue
+			}
+			props := properties(stop)
+			c, ok := parseSVGColor(props["stop-color"], color.NRGBA{A: 0xff})
+			if !ok {
+				c = color.NRGBA{A: 0xff}
+			}
+			if opacity, err := strconv.ParseFloat(props["stop-opacity"], 64); err == nil {
+				c.A = uint8(float64(c.A) * clamp01(opacity))
+			}
+			r.gradients[n.attr("id")] = c
+			return
+		}
+	}
+	for i := range n.Children {
+		r.collectGradients(&n.Children[i])
+	}
+}
+
+func (r *svgRenderer) render(n *svgNode, style svgStyle) {
+	props := properties(n)
+	if props["display"] == "none" || props["visibility"] == "hidden" {
+		return
+	}
+
+	if t, ok := parseTransform(n.attr("transform")); ok {
+		style.transform = style.transform.mul(t)
+	}
+	if c, ok := parseSVGColor(props["color"], style.color); ok {
+		style.color = c
+	}
+	if fill, ok := props["fill"]; ok {
+		style.fill = r.paint(fill, style)
+	}
+	if stroke, ok := props["stroke"]; ok {
+		style.stroke = r.paint(stroke, style)
+	}
+	if w, ok := parseLength(props["stroke-width"]); ok && w >= 0 {
+		style.strokeWidth = w
+	}
+	if v, err := strconv.ParseFloat(props["fill-opacity"], 64); err == nil {
+		style.fillOpacity = clamp01(v)
+	}
+	if v, err := strconv.ParseFloat(props["stroke-opacity"], 64); err == nil {
+		style.strokeOpacity = clamp01(v)
+	}
+	if v, err := strconv.ParseFloat(props["opacity"], 64); err == nil {
+		style.opacity *= clamp01(v)
+	}
+
+	var subpaths [][]point
+	closed := true
+	switch n.XMLName.Local {
+	case "svg", "g", "a", "switch":
+		for i := range n.Children {
+			r.render(&n.Children[i], style)
+		}
+		return
+	case "path":
+		subpaths = parsePath(n.attr("d"))
+		closed = false
+	case "rect":
+		x, y, w, h := n.length("x"), n.length("y"), n.length("width"), n.length("height")
+		if w <= 0 || h <= 0 {
+			return
+		}
+		subpaths = [][]point{{{x, y}, {x + w, y}, {x + w, y + h}, {x, y + h}, {x, y}}}
+	case "circle":
+		subpaths = [][]point{ellipse(n.length("cx"), n.length("cy"), n.length("r"), n.length("r"))}
+	case "ellipse":
+		subpaths = [][]point{ellipse(n.length("cx"), n.length("cy"), n.length("rx"), n.length("ry"))}
+	case "line":
+		subpaths = [][]point{{{n.length("x1"), n.length("y1")}, {n.length("x2"), n.length("y2")}}}
+		closed = false
+	case "polyline", "polygon":
+		numbers := parseNumbers(n.attr("points"))
+		var points []point
+		for i := 0; i+1 < len(numbers); i += 2 {
+			points = append(points, point{numbers[i], numbers[i+1]})
+		}
+		if n.XMLName.Local == "polygon" && len(points) > 0 {
+			points = append(points, points[0])
+		}
+		subpaths = [][]point{points}
+		closed = n.XMLName.Local == "polygon"
+	default:
+		return
+	}
+
+	r.elements++
+	if r.elements > MaxSVGElements {
+		return
+	}
+
+	for _, sp := range subpaths {
+		for i := range sp {
+			sp[i] = style.transform.apply(sp[i])
+		}
+	}
+
+	if style.fill.A > 0 && n.XMLName.Local != "line" {
+		for _, sp := range subpaths {
+			if len(sp) >= 3 {
+				r.polygons = append(r.polygons, sp)
+			}
+		}
+		r.draw(style.fill, style.fillOpacity*style.opacity)
+	}
+
+	if style.stroke.A > 0 && style.strokeWidth > 0 {
+		width := style.strokeWidth * math.Sqrt(math.Abs(style.transform.det()))
+		for _, sp := range subpaths {
+			r.strokePolyline(sp, width/2, closed)
+		}
+		r.draw(style.stroke, style.strokeOpacity*style.opacity)
+	}
+}
+
+// paint returns the color of a fill or stroke value, transparent for none
+func (r *svgRenderer) paint(value string, style svgStyle) color.NRGBA {
+	value = strings.TrimSpace(value)
+	if strings.HasPrefix(value, "url(") {
+		id := strings.TrimSuffix(strings.TrimPrefix(value, "url("), ")")
+		id = strings.Trim(strings.TrimSpace(id), `'"`)
+		if c, ok := r.gradients[strings.TrimPrefix(id, "#")]; ok {
+			return c
+		}
+		return color.NRGBA{A: 0xff}
+	}
+	c, _ := parseSVGColor(value, style.color)
+	return c
+}
+
+// draw fills the polygons added since the last call with the color.
+// Only the bounding box of the polygons is rasterized, which keeps small shapes on large images cheap.
+func (r *svgRenderer) draw(c color.NRGBA, opacity float64) {
+	defer func() { r.polygons = r.polygons[:0] }()
+
+	// points far outside of the canvas are pulled closer, as the rasterizer can't handle huge coordinates
+	polygons := r.polygons[:0]
+	for _, polygon := range r.polygons {
+		if clampPolygon(polygon) {
+			polygons = append(polygons, polygon)
+		}
+	}
+	r.polygons = polygons
+
+	var bounds image.Rectangle
+	for _, polygon := range r.polygons {
+		for _, p := range polygon {
+			pb := image.Rect(int(math.Floor(p.x)), int(math.Floor(p.y)), int(math.Ceil(p.x))+1, int(math.Ceil(p.y))+1)
+			bounds = bounds.Union(pb)
+		}
+	}
+	bounds = bounds.Intersect(r.dst.Bounds())
+	if bounds.Empty() {
+		return
+	}
+
+	r.raster.Reset(bounds.Dx(), bounds.Dy())
+	for _, polygon := range r.polygons {
+		r.raster.MoveTo(float32(polygon[0].x)-float32(bounds.Min.X), float32(polygon[0].y)-float32(bounds.Min.Y))
+		for _, p := range polygon[1:] {
+			r.raster.LineTo(float32(p.x)-float32(bounds.Min.X), float32(p.y)-float32(bounds.Min.Y))
+		}
+		r.raster.ClosePath()
+	}
+
+	c.A = uint8(float64(c.A) * opacity)
+	r.raster.Draw(r.dst, bounds, image.NewUniform(c), image.Point{})
+}
+
+// svgCoordinateLimit is the distance from the canvas points are clamped to before rasterizing
+const svgCoordinateLimit = 1 << 20
+
+// clampPolygon clamps the points of the polygon to a finite range around the canvas in place.
+// It returns false if the polygon has NaN coordinates and can't be drawn.
+func clampPolygon(polygon []point) bool {
+	for i, p := range polygon {
+		if math.IsNaN(p.x) || math.IsNaN(p.y) {
+			return false
+		}
+		polygon[i] = point{
+			math.Max(-svgCoordinateLimit, math.Min(svgCoordinateLimit, p.x)),
+			math.Max(-svgCoordinateLimit, math.Min(svgCoordinateLimit, p.y)),
+		}
+	}
+	return true
+}
+
+// strokePolyline adds a stroke with round joins and caps around the points to the rasterizer.
+// Every part is added with the same winding, so the overlaps don't cancel each other out.
+func (r *svgRenderer) strokePolyline(points []point, halfWidth float64, closed bool) {
+	for i := 0; i+1 < len(points); i++ {
+		a, b := points[i], points[i+1]
+		dx, dy := b.x-a.x, b.y-a.y
+		length := math.Hypot(dx, dy)
+		if length == 0 {
+			continue
+		}
+		nx, ny := -dy/length*halfWidth, dx/length*halfWidth
+		r.addPolygon([]point{{a.x + nx, a.y + ny}, {b.x + nx, b.y + ny}, {b.x - nx, b.y - ny}, {a.x - nx, a.y - ny}})
+	}
+	for i, p := range points {
+		if closed && i == len(points)-1 {
+			break
+		}
+		r.addPolygon(ellipse(p.x, p.y, halfWidth, halfWidth))
+	}
+}
+
+// addPolygon adds the polygon to be drawn in clockwise order
+func (r *svgRenderer) addPolygon(points []point) {
+	if len(points) < 3 {
+		return
+	}
+
+	area := 0.0
+	for i := range points {
+		j := (i + 1) % len(points)
+		area += points[i].x*points[j].y - points[j].x*points[i].y
+	}
+	if area < 0 {
+		for i, j := 0, len(points)-1; i < j; i, j = i+1, j-1 {
+			points[i], points[j] = points[j], points[i]
+		}
+	}
+
+	r.polygons = append(r.polygons, points)
+}
+
+// properties returns the presentation attributes of the node, overridden by its style attribute
+func properties(n *svgNode) map[string]string {
+	props := make(map[string]string)
+	for _, a := range n.Attrs {
+		props[a.Name.Local] = a.Value
+	}
+	for _, decl := range strings.Split(n.attr("style"), ";") {
+		if index := strings.IndexByte(decl, ':'); index != -1 {
+			props[strings.TrimSpace(decl[:index])] = strings.TrimSpace(decl[index+1:])
+		}
+	}
+	return props
+}
+
+type point struct {
+	x, y float64
+}
+
+func (p point) finite() bool {
+	return !math.IsInf(p.x, 0) && !math.IsNaN(p.x) && !math.IsInf(p.y, 0) && !math.IsNaN(p.y)
+}
+
+func finite(values ...float64) bool {
+	for _, f := range values {
+		if math.IsInf(f, 0) || math.IsNaN(f) {
+			return false
+		}
+	}
+	return true
+}
+
+// affine is a 2D transformation matrix in SVG order: a b c d e f
+type affine [6]float64
+
+func (t affine) apply(p point) point {
+	return point{t[0]*p.x + t[2]*p.y + t[4], t[1]*p.x + t[3]*p.y + t[5]}
+}
+
+func (t affine) mul(u affine) affine {
+	return affine{
+		t[0]*u[0] + t[2]*u[1],
+		t[1]*u[0] + t[3]*u[1],
+		t[0]*u[2] + t[2]*u[3],
+		t[1]*u[2] + t[3]*u[3],
+		t[0]*u[4] + t[2]*u[5] + t[4],
+		t[1]*u[4] + t[3]*u[5] + t[5],
+	}
+}
+
+func (t affine) det() float64 {
+	return t[0]*t[3] - t[1]*t[2]
+}
+
+// parseTransform parses a list of transform functions like translate(10 20) rotate(45)
+func parseTransform(s string) (affine, bool) {
+	t := affine{1, 0, 0, 1, 0, 0}
+	found := false
+	for {
+		open := strings.IndexByte(s, '(')
+		end := strings.IndexByte(s, ')')
+		if open == -1 || end < open {
+			return t, found
+		}
+		name := strings.Trim(strings.TrimSpace(s[:open]), ",")
+		args := parseNumbers(s[open+1 : end])
+		s = s[end+1:]
+
+		var u affine
+		switch {
+		case name == "matrix" && len(args) == 6:
+			copy(u[:], args)
+		case name == "translate" && len(args) >= 1:
+			u = affine{1, 0, 0, 1, args[0], 0}
+			if len(args) > 1 {
+				u[5] = args[1]
+			}
+		case name == "scale" && len(args) >= 1:
+			u = affine{args[0], 0, 0, args[0], 0, 0}
+			if len(args) > 1 {
+				u[3] = args[1]
+			}
+		case name == "rotate" && len(args) >= 1:
+			a := args[0] * math.Pi / 180
+			u = affine{math.Cos(a), math.Sin(a), -math.Sin(a), math.Cos(a), 0, 0}
+			if len(args) == 3 {
+				u = affine{1, 0, 0, 1, args[1], args[2]}.mul(u).mul(affine{1, 0, 0, 1, -args[1], -args[2]})
+			}
+		case name == "skewX" && len(args) == 1:
+			u = affine{1, 0, math.Tan(args[0] * math.Pi / 180), 1, 0, 0}
+		case name == "skewY" && len(args) == 1:
+			u = affine{1, math.Tan(args[0] * math.Pi / 180), 0, 1, 0, 0}
+		default:
+			continue
+		}
+		t = t.mul(u)
+		found = true
+	}
+}
+
+const curveSegments = 16
+
+// parsePath parses path data to subpaths of points, flattening curves and arcs to line segments.
+// Parsing stops at the first error or at coordinates that overflow.
+func parsePath(d string) [][]point {
+	var subpaths [][]point
+	var current []point
+	var pos, start, ctrl point
+	var prevCmd byte
+	overflow := false
+
+	s := &pathScanner{s: d}
+	for {
+		cmd, ok := s.command()
+		if !ok {
+			break
+		}
+		rel := cmd >= 'a'
+		upper := cmd &^ 0x20
+		offset := func(p point) point {
+			if rel {
+				return point{p.x + pos.x, p.y + pos.y}
+			}
+			return p
+		}
+		lineTo := func(p point) {
+			if !p.finite() {
+				overflow = true
+				return
+			}
+			if len(current) == 0 {
+				current = append(current, pos)
+			}
+			current = append(current, p)
+			pos = p
+		}
+
+		for first := true; first || s.hasNumber(); first = false {
+			switch upper {
+			case 'M':
+				p, ok := s.point()
+				if !ok {
+					return append(subpaths, current)
+				}
+				if first {
+					if len(current) > 0 {
+						subpaths = append(subpaths, current)
+					}
+					if !offset(p).finite() {
+						return subpaths
+					}
+					pos = offset(p)
+					start = pos
+					current = []point{pos}
+				} else {
+					lineTo(offset(p))
+				}
+			case 'L':
+				p, ok := s.point()
+				if !ok {
+					return append(subpaths, current)
+				}
+				lineTo(offset(p))
+			case 'H':
+				x, ok := s.number()
+				if !ok {
+					return append(subpaths, current)
+				}
+				if rel {
+					x += pos.x
+				}
+				lineTo(point{x, pos.y})
+			case 'V':
+				y, ok := s.number()
+				if !ok {
+					return append(subpaths, current)
+				}
+				if rel {
+					y += pos.y
+				}
+				lineTo(point{pos.x, y})
+			case 'C', 'S':
+				var c1 point
+				if upper == 'C' {
+					if c1, ok = s.point(); !ok {
+						return append(subpaths, current)
+					}
+					c1 = offset(c1)
+				} else if prevCmd == 'C' || prevCmd == 'S' {
+					c1 = point{2*pos.x - ctrl.x, 2*pos.y - ctrl.y}
+				} else {
+					c1 = pos
+				}
+				c2, ok2 := s.point()
+				p, ok3 := s.point()
+				if !ok2 || !ok3 {
+					return append(subpaths, current)
+				}
+				c2, p = offset(c2), offset(p)
+				p0 := pos
+				for i := 1; i <= curveSegments; i++ {
+					t := float64(i) / curveSegments
+					mt := 1 - t
+					lineTo(point{
+						mt*mt*mt*p0.x + 3*mt*mt*t*c1.x + 3*mt*t*t*c2.x + t*t*t*p.x,
+						mt*mt*mt*p0.y + 3*mt*mt*t*c1.y + 3*mt*t*t*c2.y + t*t*t*p.y,
+					})
+				}
+				ctrl = c2
+			case 'Q', 'T':
+				var c point
+				if upper == 'Q' {
+					if c, ok = s.point(); !ok {
+						return append(subpaths, current)
+					}
+					c = offset(c)
+				} else if prevCmd == 'Q' || prevCmd == 'T' {
+					c = point{2*pos.x - ctrl.x, 2*pos.y - ctrl.y}
+				} else {
+					c = pos
+				}
+				p, ok := s.point()
+				if !ok {
+					return append(subpaths, current)
+				}
+				p = offset(p)
+				p0 := pos
+				for i := 1; i <= curveSegments; i++ {
+					t := float64(i) / curveSegments
+					mt := 1 - t
+					lineTo(point{
+						mt*mt*p0.x + 2*mt*t*c.x + t*t*p.x,
+						mt*mt*p0.y + 2*mt*t*c.y + t*t*p.y,
+					})
+				}
+				ctrl = c
+			case 'A':
+				rx, ok1 := s.number()
+				ry, ok2 := s.number()
+				rotation, ok3 := s.number()
+				large, ok4 := s.flag()
+				sweep, ok5 := s.flag()
+				p, ok6 := s.point()
+				if !ok1 || !ok2 || !ok3 || !ok4 || !ok5 || !ok6 {
+					return append(subpaths, current)
+				}
+				p = offset(p)
+				for _, q := range arc(pos, p, rx, ry, rotation, large, sweep) {
+					lineTo(q)
+				}
+			case 'Z':
+				if len(current) > 0 {
+					current = append(current, start)
+					subpaths = append(subpaths, current)
+					current = nil
+				}
+				pos = start
+			default:
+				return append(subpaths, current)
+			}
+			if overflow {
+				return append(subpaths, current)
+			}
+			prevCmd = upper
+			if upper == 'Z' {
+				break
+			}
+		}
+	}
+
+	if len(current) > 0 {
+		subpaths = append(subpaths, current)
+	}
+	return subpaths
+}
+
+// arc returns the points of an elliptical arc from p0 to p (excluding p0) as described in the SVG spec
+func arc(p0, p point, rx, ry, rotation float64, large, sweep bool) []point {
+	rx, ry = math.Abs(rx), math.Abs(ry)
+	if rx == 0 || ry == 0 || p0 == p || !p0.finite() || !p.finite() || !finite(rx*rx*ry*ry, rotation) {
+		return []point{p}
+	}
+
+	phi := rotation * math.Pi / 180
+	cos, sin := math.Cos(phi), math.Sin(phi)
+	dx, dy := (p0.x-p.x)/2, (p0.y-p.y)/2
+	x1, y1 := cos*dx+sin*dy, -sin*dx+cos*dy
+
+	if lambda := x1*x1/(rx*rx) + y1*y1/(ry*ry); lambda > 1 {
+		rx, ry = rx*math.Sqrt(lambda), ry*math.Sqrt(lambda)
+	}
+
+	num := rx*rx*ry*ry - rx*rx*y1*y1 - ry*ry*x1*x1
+	den := rx*rx*y1*y1 + ry*ry*x1*x1
+	coef := 0.0
+	if den > 0 && num > 0 {
+		coef = math.Sqrt(num / den)
+	}
+	if large == sweep {
+		coef = -coef
+	}
+	cx1, cy1 := coef*rx*y1/ry, -coef*ry*x1/rx
+	cx := cos*cx1 - sin*cy1 + (p0.x+p.x)/2
+	cy := sin*cx1 + cos*cy1 + (p0.y+p.y)/2
+
+	angle := func(ux, uy, vx, vy float64) float64 {
+		return math.Atan2(ux*vy-uy*vx, ux*vx+uy*vy)
+	}
+	theta := angle(1, 0, (x1-cx1)/rx, (y1-cy1)/ry)
+	delta := angle((x1-cx1)/rx, (y1-cy1)/ry, (-x1-cx1)/rx, (-y1-cy1)/ry)
+	if !sweep && delta > 0 {
+		delta -= 2 * math.Pi
+	} else if sweep && delta < 0 {
+		delta += 2 * math.Pi
+	}
+	if !finite(cx, cy, theta, delta) {
+		return []point{p}
+	}
+
+	segments := int(math.Ceil(math.Abs(delta) / (math.Pi / 8)))
+	if segments < 1 {
+		segments = 1
+	}
+	points := make([]point, 0, segments)
+	for i := 1; i <= segments; i++ {
+		a := theta + delta*float64(i)/float64(segments)
+		x, y := rx*math.Cos(a), ry*math.Sin(a)
+		points = append(points, point{cos*x - sin*y + cx, sin*x + cos*y + cy})
+	}
+	points[len(points)-1] = p
+	return points
+}
+
+// ellipse returns the points of an ellipse as a closed polygon
+func ellipse(cx, cy, rx, ry float64) []point {
+	if rx <= 0 || ry <= 0 || !finite(cx-rx, cx+rx, cy-ry, cy+ry) {
+		return nil
+	}
+	const segments = 32
+	points := make([]point, 0, segments+1)
+	for i := 0; i <= segments; i++ {
+		a := 2 * math.Pi * float64(i) / segments
+		points = append(points, point{cx + rx*math.Cos(a), cy + ry*math.Sin(a)})
+	}
+	return points
+}
+
+// pathScanner reads commands and numbers of path data
+type pathScanner struct {
+	s   string
+	pos int
+}
+
+func (s *pathScanner) skip() {
+	for s.pos < len(s.s) && strings.IndexByte(" \t\r\n,", s.s[s.pos]) != -1 {
+		s.pos++
+	}
+}
+
+func (s *pathScanner) command() (byte, bool) {
+	s.skip()
+	if s.pos >= len(s.s) {
+		return 0, false
+	}
+	c := s.s[s.pos]
+	if strings.IndexByte("MmLlHhVvCcSsQqTtAaZz", c) == -1 {
+		return 0, false
+	}
+	s.pos++
+	return c, true
+}
+
+func (s *pathScanner) hasNumber() bool {
+	s.skip()
+	return s.pos < len(s.s) && strings.IndexByte("+-.0123456789", s.s[s.pos]) != -1
+}
+
+func (s *pathScanner) number() (float64, bool) {
+	s.skip()
+	start := s.pos
+	if s.pos < len(s.s) && (s.s[s.pos] == '+' || s.s[s.pos] == '-') {
+		s.pos++
+	}
+	dot, exp := false, false
+	for s.pos < len(s.s) {
+		c := s.s[s.pos]
+		switch {
+		case c >= '0' && c <= '9':
+		case c == '.' && !dot && !exp:
+			dot = true
+		case (c == 'e' || c == 'E') && !exp && s.pos > start:
+			exp = true
+			if s.pos+1 < len(s.s) && (s.s[s.pos+1] == '+' || s.s[s.pos+1] == '-') {
+				s.pos++
+			}
+		default:
+			return s.parse(start)
+		}
+		s.pos++
+	}
+	return s.parse(start)
+}
+
+func (s *pathScanner) parse(start int) (float64, bool) {
+	f, err := strconv.ParseFloat(s.s[start:s.pos], 64)
+	return f, err == nil
+}
+
+func (s *pathScanner) point() (point, bool) {
+	x, ok1 := s.number()
+	y, ok2 := s.number()
+	return point{x, y}, ok1 && ok2
+}
+
+// flag reads an arc flag, which doesn't need to be separated from the next number
+func (s *pathScanner) flag() (bool, bool) {
+	s.skip()
+	if s.pos >= len(s.s) || (s.s[s.pos] != '0' && s.s[s.pos] != '1') {
+		return false, false
+	}
+	s.pos++
+	return s.s[s.pos-1] == '1', true
+}
+
+// parseNumbers parses a list of numbers separated by spaces or commas
+func parseNumbers(s string) []float64 {
+	var numbers []float64
+	scanner := &pathScanner{s: s}
+	for scanner.hasNumber() {
+		f, ok := scanner.number()
+		if !ok {
+			break
+		}
+		numbers = append(numbers, f)
+	}
+	return numbers
+}
+
+// parseLength parses a length in user units (px), returns false for missing, relative or unsupported lengths
+func parseLength(s string) (float64, bool) {
+	s = strings.TrimSuffix(strings.TrimSpace(s), "px")
+	f, err := strconv.ParseFloat(s, 64)
+	return f, err == nil
+}
+
+// length returns the length attribute of the node or 0 if it's missing
+func (n *svgNode) length(name string) float64 {
+	f, _ := parseLength(n.attr(name))
+	return f
+}
+
+var svgColors = map[string]color.NRGBA{
+	"black":   {0x00, 0x00, 0x00, 0xff},
+	"white":   {0xff, 0xff, 0xff, 0xff},
+	"red":     {0xff, 0x00, 0x00, 0xff},
+	"green":   {0x00, 0x80, 0x00, 0xff},
+	"blue":    {0x00, 0x00, 0xff, 0xff},
+	"yellow":  {0xff, 0xff, 0x00, 0xff},
+	"orange":  {0xff, 0xa5, 0x00, 0xff},
+	"purple":  {0x80, 0x00, 0x80, 0xff},
+	"gray":    {0x80, 0x80, 0x80, 0xff},
+	"grey":    {0x80, 0x80, 0x80, 0xff},
+	"silver":  {0xc0, 0xc0, 0xc0, 0xff},
+	"navy":    {0x00, 0x00, 0x80, 0xff},
+	"teal":    {0x00, 0x80, 0x80, 0xff},
+	"maroon":  {0x80, 0x00, 0x00, 0xff},
+	"olive":   {0x80, 0x80, 0x00, 0xff},
+	"lime":    {0x00, 0xff, 0x00, 0xff},
+	"aqua":    {0x00, 0xff, 0xff, 0xff},
+	"cyan":    {0x00, 0xff, 0xff, 0xff},
+	"fuchsia": {0xff, 0x00, 0xff, 0xff},
+	"magenta": {0xff, 0x00, 0xff, 0xff},
+}
+
+// parseSVGColor parses a color value, currentColor being replaced by current.
+// It returns false if the value is not a known color.
+func parseSVGColor(s string, current color.NRGBA) (color.NRGBA, bool) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	switch {
+	case len(s) == 0:
+		return color.NRGBA{}, false
+	case s == "none", s == "transparent":
+		return color.NRGBA{}, true
+	case s == "currentcolor":
+		return current, true
+	case strings.HasPrefix(s, "#"):
+		if len(s) != 4 && len(s) != 7 {
+			return color.NRGBA{}, false
+		}
+		c, err := parseColor(s)
+		return c, err == nil
+	case strings.HasPrefix(s, "rgb(") || strings.HasPrefix(s, "rgba("):
+		args := strings.Split(strings.TrimSuffix(s[strings.IndexByte(s, '(')+1:], ")"), ",")
+		if len(args) < 3 {
+			return color.NRGBA{}, false
+		}
+		var rgba [4]float64
+		rgba[3] = 1
+		for i := 0; i < len(args) && i < 4; i++ {
+			arg := strings.TrimSpace(args[i])
+			if strings.HasSuffix(arg, "%") {
+				f, _ := strconv.ParseFloat(strings.TrimSuffix(arg, "%"), 64)
+				rgba[i] = f / 100
+				if i < 3 {
+					rgba[i] *= 255
+				}
+				continue
+			}
+			rgba[i], _ = strconv.ParseFloat(arg, 64)
+		}
+		return color.NRGBA{
+			R: uint8(math.Min(math.Max(rgba[0], 0), 255)),
+			G: uint8(math.Min(math.Max(rgba[1], 0), 255)),
+			B: uint8(math.Min(math.Max(rgba[2], 0), 255)),
+			A: uint8(clamp01(rgba[3]) * 255),
+		}, true
+	default:
+		c, ok := svgColors[s]
+		return c, ok
+	}
+}
+
+func clamp01(f float64) float64 {
+	return math.Min(math.Max(f, 0), 1)
+}
@@ -0,0 +1,68 @@
+package thumb
+
+import (
+	"image/color"
+	"testing"
+)
+
+func TestRasterizeSVG(t *testing.T) {
+	svg := `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 100 50">
+		<rect width="50" height="50" fill="red"/>
+		<path d="M 50 0 H 100 V 50 H 50 Z" fill="#00f"/>
+	</svg>`
+	img, err := rasterizeSVG([]byte(svg), DefaultOptions())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := img.Bounds(); b.Dx() != 256 || b.Dy() != 128 {
+		t.Fatalf("unexpected size: %v", b)
+	}
+
+	tests := []struct {
+		x, y int
+		want color.RGBA
+	}{
+		{64, 64, color.RGBA{0xff, 0, 0, 0xff}},
+		{192, 64, color.RGBA{0, 0, 0xff, 0xff}},
+	}
+	for _, tt := range tests {
+		if got := color.RGBAModel.Convert(img.At(tt.x, tt.y)); got != tt.want {
+			t.Errorf("pixel at %d,%d = %v, want %v", tt.x, tt.y, got, tt.want)
+		}
+	}
+}
+
+func TestRasterizeSVGExtremeValues(t *testing.T) {
+	shapes := []string{
+		`<rect x="-1e300" width="1e300" height="10"/>`,
+		`<rect x="-1e308" y="-1e308" width="1e308" height="1e308" stroke="red" stroke-width="1e300"/>`,
+		`<path d="M 1e308 1e308 A 1 1 0 0 1 -1e308 -1e308"/>`,
+		`<path d="M 0 0 A 1e200 1e200 0 0 1 10 10 L 0 10 Z" stroke="red"/>`,
+		`<path d="M 0 0 a 1e200 1 0 1 1 10 0 z"/>`,
+		`<path d="M 1e308 0 l 1e308 0 l 0 10 z"/>`,
+		`<path d="M 0 0 C 1e308 1e308 -1e308 -1e308 10 10 Z"/>`,
+		`<circle cx="1e308" cy="0" r="1e308"/>`,
+		`<ellipse cx="50" cy="50" rx="1e-300" ry="1e300"/>`,
+		`<polygon points="0,0 1e308,1e308 -1e308,1e308"/>`,
+		`<line x1="-1e300" y1="0" x2="1e300" y2="10" stroke="black" stroke-width="5"/>`,
+		`<g transform="scale(1e300)"><rect width="10" height="10"/></g>`,
+		`<g transform="matrix(1e308 1e308 1e308 1e308 0 0)"><circle r="10" stroke="red"/></g>`,
+	}
+	for _, shape := range shapes {
+		svg := `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 100 100">` + shape + `</svg>`
+		if _, err := rasterizeSVG([]byte(svg), DefaultOptions()); err != nil {
+			t.Errorf("%s: %v", shape, err)
+		}
+	}
+}
+
+func FuzzRasterizeSVG(f *testing.F) {
+	f.Add(`<svg viewBox="0 0 10 10"><rect width="5" height="5"/></svg>`)
+	f.Add(`<svg width="20" height="10"><path d="M0 0L10 10A5 5 0 0 1 20 0Z" stroke="red"/></svg>`)
+	f.Add(`<svg viewBox="0 0 100 100"><circle cx="50" cy="50" r="40" transform="rotate(30 50 50)"/></svg>`)
+	f.Fuzz(func(t *testing.T, svg string) {
+		opts := DefaultOptions()
+		opts.Width, opts.Height = 64, 64
+		rasterizeSVG([]byte(svg), opts)
+	})
+}
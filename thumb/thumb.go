@@ -3,22 +3,24 @@ package thumb
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"image"
 	"image/color"
+	"image/color/palette"
 	"image/draw"
 	"image/gif"
 	"image/jpeg"
 	"image/png"
 	"io"
-	"mime"
+	"io/ioutil"
 	"net/http"
 	"strconv"
 	"strings"
 
-	"github.com/golang/freetype"
-	"github.com/nfnt/resize"
-	"golang.org/x/image/math/fixed"
+	"github.com/razzie/mediaserver/fetch"
+	"golang.org/x/image/bmp"
+	"golang.org/x/image/tiff"
 	"golang.org/x/image/webp"
 )
 
@@ -27,20 +29,46 @@ func init() {
 	image.RegisterFormat("gif", "gif", gif.Decode, gif.DecodeConfig)
 	image.RegisterFormat("png", "png", png.Decode, png.DecodeConfig)
 	image.RegisterFormat("webp", "webp", webp.Decode, webp.DecodeConfig)
+	image.RegisterFormat("bmp", "BM????\x00\x00\x00\x00", bmp.Decode, bmp.DecodeConfig)
+	image.RegisterFormat("tiff", "II\x2A\x00", tiff.Decode, tiff.DecodeConfig)
+	image.RegisterFormat("tiff", "MM\x00\x2A", tiff.Decode, tiff.DecodeConfig)
+	image.RegisterFormat("ico", "\x00\x00\x01\x00", decodeICO, decodeICOConfig)
 }
 
-var (
-	// Size is max width or height of the thumbnail image
-	Size uint = 256
-	// Quality is the thumbnail jpeg quality
-	Quality int = 90
-)
+// MaxPixels is the maximum number of pixels (width*height) of an image that can be processed
+var MaxPixels = 50 * 1000 * 1000
+
+// ErrTooManyPixels is returned when the dimensions of an image exceed MaxPixels
+var ErrTooManyPixels = errors.New("image dimensions too large")
 
 // Thumbnail contains a thumbnail image in bytes + the MIME type and bounds
 type Thumbnail struct {
-	Data   []byte          `json:"data"`
-	MIME   string          `json:"mime"`
-	Bounds image.Rectangle `json:"bounds"`
+	Data      []byte          `json:"data"`
+	MIME      string          `json:"mime"`
+	Bounds    image.Rectangle `json:"bounds"`
+	Generated bool            `json:"generated,omitempty"` // rendered from text, not made of an image
+}
+
+// Info describes a thumbnail without the image data
+type Info struct {
+	URL       string `json:"url"`
+	MIME      string `json:"mime"`
+	Width     int    `json:"width"`
+	Height    int    `json:"height"`
+	Size      int    `json:"size"`
+	Generated bool   `json:"generated"`
+}
+
+// Info returns the details of the thumbnail, url being the address it's served at
+func (t Thumbnail) Info(url string) *Info {
+	return &Info{
+		URL:       url,
+		MIME:      t.MIME,
+		Width:     t.Bounds.Dx(),
+		Height:    t.Bounds.Dy(),
+		Size:      len(t.Data),
+		Generated: t.Generated,
+	}
 }
 
 func (t Thumbnail) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -49,66 +77,163 @@ func (t Thumbnail) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	w.Write(t.Data)
 }
 
-// Get reads an image from an io.Reader and returns the thumbnail
-func Get(img io.Reader, label string) (*Thumbnail, error) {
-	src, _, err := image.Decode(img)
+// Get reads an image from an io.Reader and returns the thumbnail.
+// The image is processed by DefaultPool, so ErrBusy is returned if its queue is full.
+// The thumbnail is always re-encoded, so metadata like EXIF (and GPS location) is never copied.
+func Get(img io.Reader, label string, opts Options) (*Thumbnail, error) {
+	data, err := ioutil.ReadAll(img)
 	if err != nil {
 		return nil, err
 	}
 
-	dst := resize.Thumbnail(Size, Size, src, resize.NearestNeighbor)
+	var t *Thumbnail
+	if poolErr := DefaultPool.Do(func() {
+		t, err = process(data, label, opts)
+	}); poolErr != nil {
+		return nil, poolErr
+	}
 
-	if len(label) > 0 {
-		dst = toDrawImage(dst)
+	return t, err
+}
 
-		b := dst.Bounds()
-		width := b.Dx() - 16
-		height := b.Dy() - 16
+func process(data []byte, label string, opts Options) (*Thumbnail, error) {
+	if isSVG(data) {
+		// vector images are rasterized right at the thumbnail size
+		src, err := rasterizeSVG(data, opts)
+		if err != nil {
+			return nil, err
+		}
+		return render(src, label, opts)
+	}
 
-		if width > 24 && height > 24 {
-			maxLen := width / 7
-			if len(label) > maxLen {
-				label = label[:maxLen] + ".."
-			}
-			addLabel(dst, 7, height+7, color.Black, label)
-			addLabel(dst, 6, height+6, color.White, label)
+	// check the dimensions before allocating memory for the whole image
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if int64(config.Width)*int64(config.Height) > int64(MaxPixels) {
+		return nil, fmt.Errorf("%w: %dx%d", ErrTooManyPixels, config.Width, config.Height)
+	}
+
+	if opts.Animated && (opts.Format == "auto" || opts.Format == "gif") {
+		if t, ok := animatedThumbnail(data, config, label, opts); ok {
+			return t, nil
 		}
 	}
 
-	var result bytes.Buffer
-	err = jpeg.Encode(&result, dst, &jpeg.Options{Quality: Quality})
+	src, format, err := image.Decode(bytes.NewReader(data))
 	if err != nil {
 		return nil, err
 	}
 
-	return &Thumbnail{
-		Data:   result.Bytes(),
-		MIME:   "image/jpeg",
-		Bounds: dst.Bounds(),
-	}, nil
+	if format == "jpeg" {
+		src = applyOrientation(src, jpegOrientation(data))
+	}
+
+	return render(src, label, opts)
+}
+
+// render resizes the image and draws the label on it before encoding
+func render(src image.Image, label string, opts Options) (*Thumbnail, error) {
+	region, width, height := layout(src, opts)
+	dst := resizeRegion(src, region, width, height, opts.Filter)
+	dst = drawLabel(dst, label, opts)
+
+	return encode(dst, opts)
 }
 
 // GetFromURL downloads the image at the given URL and returns the thumbnail
-func GetFromURL(ctx context.Context, url, label string) (*Thumbnail, error) {
-	req, err := http.NewRequest("GET", url, nil)
+func GetFromURL(ctx context.Context, url, label string, opts Options) (*Thumbnail, error) {
+	resp, err := fetch.Get(ctx, url, "image/*")
 	if err != nil {
 		return nil, err
 	}
-	req.Header.Set("accept", "image/*")
+	defer resp.Body.Close()
 
-	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	contentType := fetch.Sniff(resp)
+	if !strings.HasPrefix(contentType, "image/") {
+		return nil, fmt.Errorf("unsupported image content type: %s (%s)", contentType, url)
+	}
+
+	return Get(resp.Body, label, opts)
+}
+
+// encode encodes the image in the requested format.
+// In auto mode transparent images become png, the rest jpeg.
+// Transparent images are flattened to the background color if it's set or if the format doesn't support alpha.
+func encode(img image.Image, opts Options) (*Thumbnail, error) {
+	var result bytes.Buffer
+	var err error
+
+	bg := color.NRGBA{0xff, 0xff, 0xff, 0xff}
+	if len(opts.Background) > 0 {
+		bg, err = parseColor(opts.Background)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if !isOpaque(img) && (len(opts.Background) > 0 || opts.Format == "jpeg") {
+		img = flatten(img, bg)
+	}
+
+	if opts.Format == "auto" {
+		if isOpaque(img) {
+			opts.Format = "jpeg"
+		} else {
+			opts.Format = "png"
+		}
+	}
+
+	switch opts.Format {
+	case "png":
+		err = png.Encode(&result, img)
+	case "gif":
+		// the encoder would quantize transparent pixels to black, so they get a transparent palette index instead
+		if _, paletted := img.(*image.Paletted); !paletted && !isOpaque(img) {
+			img = quantize(img, palette.Plan9)
+		}
+		err = gif.Encode(&result, img, nil)
+	case "webp":
+		err = encodeWebP(&result, img)
+	default:
+		opts.Format = "jpeg"
+		err = jpeg.Encode(&result, img, &jpeg.Options{Quality: opts.Quality})
+	}
 	if err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
 
-	contentType := resp.Header.Get("Content-type")
-	t, _, err := mime.ParseMediaType(contentType)
-	if !strings.HasPrefix(t, "image/") {
-		return nil, fmt.Errorf("unsupported image content type: %s (%s)", contentType, url)
+	return &Thumbnail{
+		Data:   result.Bytes(),
+		MIME:   "image/" + opts.Format,
+		Bounds: img.Bounds(),
+	}, nil
+}
+
+func isOpaque(img image.Image) bool {
+	if o, ok := img.(interface{ Opaque() bool }); ok {
+		return o.Opaque()
 	}
 
-	return Get(resp.Body, label)
+	b := img.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if _, _, _, a := img.At(x, y).RGBA(); a != 0xffff {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// flatten draws the image over a solid background color
+func flatten(img image.Image, bg color.Color) image.Image {
+	b := img.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(dst, dst.Bounds(), image.NewUniform(bg), image.Point{}, draw.Src)
+	draw.Draw(dst, dst.Bounds(), img, b.Min, draw.Over)
+	return dst
 }
 
 func toDrawImage(src image.Image) draw.Image {
@@ -122,19 +247,3 @@ func toDrawImage(src image.Image) draw.Image {
 	draw.Draw(dst, dst.Bounds(), src, b.Min, draw.Src)
 	return dst
 }
-
-func addLabel(img image.Image, x, y int, col color.Color, label string) {
-	point := fixed.Point26_6{
-		X: fixed.Int26_6(x * 64),
-		Y: fixed.Int26_6(y * 64),
-	}
-
-	c := freetype.NewContext()
-	c.SetDPI(72)
-	c.SetFont(Font)
-	c.SetFontSize(13)
-	c.SetClip(img.Bounds())
-	c.SetDst(img.(draw.Image))
-	c.SetSrc(image.NewUniform(col))
-	c.DrawString(label, point)
-}
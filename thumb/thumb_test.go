@@ -0,0 +1,36 @@
+package thumb
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/gif"
+	"testing"
+)
+
+func TestEncodeGIFTransparent(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(0, 0, 20, 10))
+	for x := 10; x < 20; x++ {
+		for y := 0; y < 10; y++ {
+			img.Set(x, y, color.NRGBA{0xff, 0, 0, 0xff})
+		}
+	}
+
+	opts := DefaultOptions()
+	opts.Format = "gif"
+	thumbnail, err := encode(img, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := gif.Decode(bytes.NewReader(thumbnail.Data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, _, a := decoded.At(5, 5).RGBA(); a != 0 {
+		t.Errorf("transparent pixel has alpha %#x", a)
+	}
+	if got := color.RGBAModel.Convert(decoded.At(15, 5)); got != (color.RGBA{0xff, 0, 0, 0xff}) {
+		t.Errorf("red pixel = %v", got)
+	}
+}
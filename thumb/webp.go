@@ -0,0 +1,527 @@
+package thumb
+
+import (
+	"container/heap"
+	"encoding/binary"
+	"image"
+	"image/draw"
+	"io"
+	"math/bits"
+)
+
+// encodeWebP writes the image in lossless WebP (VP8L) format.
+// Only the subtract green and predictor transforms are used and backward references only repeat
+// the left or the above pixels, so the output is larger than what libwebp would produce, but it's simple and pure Go.
+func encodeWebP(w io.Writer, img image.Image) error {
+	b := img.Bounds()
+	src, ok := img.(*image.NRGBA)
+	if !ok || src.Bounds().Min != (image.Point{}) {
+		src = image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+		draw.Draw(src, src.Bounds(), img, b.Min, draw.Src)
+	}
+
+	width, height := src.Bounds().Dx(), src.Bounds().Dy()
+	pixels := make([][4]uint8, 0, width*height) // red, green, blue, alpha
+	hasAlpha := false
+	for y := 0; y < height; y++ {
+		row := src.Pix[y*src.Stride : y*src.Stride+width*4]
+		for x := 0; x < width*4; x += 4 {
+			r, g, b, a := row[x], row[x+1], row[x+2], row[x+3]
+			pixels = append(pixels, [4]uint8{r - g, g, b - g, a}) // subtract green
+			hasAlpha = hasAlpha || a != 0xff
+		}
+	}
+
+	var bw bitWriter
+	bw.write(0x2f, 8) // signature
+	bw.write(uint32(width-1), 14)
+	bw.write(uint32(height-1), 14)
+	bw.writeBool(hasAlpha)
+	bw.write(0, 3) // version
+
+	bw.write(1, 1) // transform present
+	bw.write(2, 2) // subtract green
+
+	bw.write(1, 1) // transform present
+	bw.write(0, 2) // predictor
+	bw.write(predictorBits-2, 3)
+	modes, residuals := predict(pixels, width, height)
+	tilesX := (width + 1<<predictorBits - 1) >> predictorBits
+	writeEntropyImage(&bw, modes, tilesX, false)
+
+	bw.write(0, 1) // no more transforms
+
+	writeEntropyImage(&bw, residuals, width, true)
+
+	data := bw.bytes()
+	padding := len(data) & 1
+
+	var header [20]byte
+	copy(header[0:], "RIFF")
+	binary.LittleEndian.PutUint32(header[4:], uint32(12+len(data)+padding))
+	copy(header[8:], "WEBPVP8L")
+	binary.LittleEndian.PutUint32(header[16:], uint32(len(data)))
+
+	if _, err := w.Write(header[:]); err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+	if padding > 0 {
+		_, err := w.Write([]byte{0})
+		return err
+	}
+	return nil
+}
+
+// predictorBits is the log2 of the predictor tile size
+const predictorBits = 4
+
+// predictorModes are the tried predictor modes: left, top and the average of them
+var predictorModes = [...]uint8{1, 2, 7}
+
+// predict chooses the predictor mode of each tile that results in the smallest residuals,
+// and returns the modes as a sub-image (mode stored in green) along with the residuals
+func predict(pixels [][4]uint8, width, height int) (modes, residuals [][4]uint8) {
+	tileSize := 1 << predictorBits
+	tilesX := (width + tileSize - 1) / tileSize
+	tilesY := (height + tileSize - 1) / tileSize
+
+	prediction := func(mode uint8, x, y int) [4]uint8 {
+		switch {
+		case x == 0 && y == 0:
+			return [4]uint8{0, 0, 0, 0xff}
+		case y == 0:
+			return pixels[x-1]
+		case x == 0:
+			return pixels[(y-1)*width]
+		}
+
+		left, top := pixels[y*width+x-1], pixels[(y-1)*width+x]
+		switch mode {
+		case 1:
+			return left
+		case 2:
+			return top
+		default:
+			var avg [4]uint8
+			for c := range avg {
+				avg[c] = uint8((uint16(left[c]) + uint16(top[c])) / 2)
+			}
+			return avg
+		}
+	}
+
+	modes = make([][4]uint8, tilesX*tilesY)
+	residuals = make([][4]uint8, len(pixels))
+	for ty := 0; ty < tilesY; ty++ {
+		for tx := 0; tx < tilesX; tx++ {
+			bestMode, bestCost := predictorModes[0], -1
+			for _, mode := range predictorModes {
+				cost := 0
+				for y := ty * tileSize; y < height && y < (ty+1)*tileSize; y++ {
+					for x := tx * tileSize; x < width && x < (tx+1)*tileSize; x++ {
+						pred := prediction(mode, x, y)
+						for c, v := range pixels[y*width+x] {
+							diff := int(int8(v - pred[c]))
+							if diff < 0 {
+								diff = -diff
+							}
+							cost += diff
+						}
+					}
+				}
+				if bestCost == -1 || cost < bestCost {
+					bestMode, bestCost = mode, cost
+				}
+			}
+
+			modes[ty*tilesX+tx] = [4]uint8{0, bestMode, 0, 0xff}
+			for y := ty * tileSize; y < height && y < (ty+1)*tileSize; y++ {
+				for x := tx * tileSize; x < width && x < (tx+1)*tileSize; x++ {
+					pred := prediction(bestMode, x, y)
+					for c, v := range pixels[y*width+x] {
+						residuals[y*width+x][c] = v - pred[c]
+					}
+				}
+			}
+		}
+	}
+	return
+}
+
+// writeEntropyImage writes ARGB pixels using a single group of prefix codes.
+// Runs of pixels that repeat the left or the above pixels are coded as backward references.
+func writeEntropyImage(bw *bitWriter, pixels [][4]uint8, width int, topLevel bool) {
+	// symbols are coded in green, red, blue, alpha order
+	order := [4]int{1, 0, 2, 3}
+
+	var hist [5][]uint32
+	hist[0] = make([]uint32, 256+24) // green + length prefix codes
+	for i := 1; i < 4; i++ {
+		hist[i] = make([]uint32, 256)
+	}
+	hist[4] = make([]uint32, 40) // distance prefix codes
+
+	refs := findBackwardRefs(pixels, width)
+	for i := 0; i < len(pixels); {
+		if ref, ok := refs[i]; ok {
+			lengthSymbol, _, _ := lz77Prefix(ref.length)
+			distSymbol, _, _ := lz77Prefix(ref.distCode)
+			hist[0][256+lengthSymbol]++
+			hist[4][distSymbol]++
+			i += ref.length
+			continue
+		}
+		for j, c := range order {
+			hist[j][pixels[i][c]]++
+		}
+		i++
+	}
+
+	bw.write(0, 1) // no color cache
+	if topLevel {
+		bw.write(0, 1) // no meta prefix codes
+	}
+
+	var codes [5]*prefixCode
+	for i := range codes {
+		codes[i] = writePrefixCode(bw, hist[i], 15)
+	}
+
+	for i := 0; i < len(pixels); {
+		if ref, ok := refs[i]; ok {
+			symbol, extra, extraBits := lz77Prefix(ref.length)
+			codes[0].write(bw, 256+symbol)
+			bw.write(extra, extraBits)
+			symbol, extra, extraBits = lz77Prefix(ref.distCode)
+			codes[4].write(bw, symbol)
+			bw.write(extra, extraBits)
+			i += ref.length
+			continue
+		}
+		for j, c := range order {
+			codes[j].write(bw, int(pixels[i][c]))
+		}
+		i++
+	}
+}
+
+type backwardRef struct {
+	length   int
+	distCode int // 1 = pixel above, 2 = pixel on the left
+}
+
+// findBackwardRefs greedily finds runs of pixels that are equal to their left or above neighbors
+func findBackwardRefs(pixels [][4]uint8, width int) map[int]backwardRef {
+	const minLength, maxLength = 3, 4096
+
+	refs := make(map[int]backwardRef)
+	for i := 1; i < len(pixels); {
+		left := 0
+		for i+left < len(pixels) && left < maxLength && pixels[i+left] == pixels[i-1] {
+			left++
+		}
+		above := 0
+		if i >= width {
+			for i+above < len(pixels) && above < maxLength && pixels[i+above] == pixels[i+above-width] {
+				above++
+			}
+		}
+
+		switch {
+		case above >= minLength && above >= left:
+			refs[i] = backwardRef{length: above, distCode: 1}
+			i += above
+		case left >= minLength:
+			refs[i] = backwardRef{length: left, distCode: 2}
+			i += left
+		default:
+			i++
+		}
+	}
+	return refs
+}
+
+// lz77Prefix returns the prefix symbol and extra bits of a backward reference length or distance
+func lz77Prefix(value int) (symbol int, extra uint32, extraBits uint) {
+	d := value - 1
+	if d < 4 {
+		return d, 0, 0
+	}
+	high := bits.Len(uint(d)) - 1
+	second := (d >> uint(high-1)) & 1
+	extraBits = uint(high - 1)
+	return 2*high + second, uint32(d) & (1<<extraBits - 1), extraBits
+}
+
+type bitWriter struct {
+	buf  []byte
+	acc  uint64
+	bits uint
+}
+
+func (bw *bitWriter) write(value uint32, bits uint) {
+	bw.acc |= uint64(value) << bw.bits
+	bw.bits += bits
+	for bw.bits >= 8 {
+		bw.buf = append(bw.buf, byte(bw.acc))
+		bw.acc >>= 8
+		bw.bits -= 8
+	}
+}
+
+func (bw *bitWriter) writeBool(value bool) {
+	if value {
+		bw.write(1, 1)
+	} else {
+		bw.write(0, 1)
+	}
+}
+
+func (bw *bitWriter) bytes() []byte {
+	if bw.bits > 0 {
+		bw.buf = append(bw.buf, byte(bw.acc))
+		bw.acc, bw.bits = 0, 0
+	}
+	return bw.buf
+}
+
+// prefixCode is a canonical Huffman code with bit reversed codes, ready to be written LSB first
+type prefixCode struct {
+	codes   []uint32
+	lengths []uint8
+}
+
+func (c *prefixCode) write(bw *bitWriter, symbol int) {
+	if n := c.lengths[symbol]; n > 0 {
+		bw.write(c.codes[symbol], uint(n))
+	}
+}
+
+func newPrefixCode(lengths []uint8) *prefixCode {
+	var count [16]uint32
+	used := 0
+	for _, n := range lengths {
+		if n > 0 {
+			count[n]++
+			used++
+		}
+	}
+
+	c := &prefixCode{
+		codes:   make([]uint32, len(lengths)),
+		lengths: make([]uint8, len(lengths)),
+	}
+	if used < 2 {
+		return c // a single symbol is coded with zero bits
+	}
+
+	var next [16]uint32
+	code := uint32(0)
+	for n := 1; n < 16; n++ {
+		code = (code + count[n-1]) << 1
+		next[n] = code
+	}
+
+	for symbol, n := range lengths {
+		if n == 0 {
+			continue
+		}
+		code := next[n]
+		next[n]++
+		reversed := uint32(0)
+		for i := uint8(0); i < n; i++ {
+			reversed = reversed<<1 | (code>>i)&1
+		}
+		c.codes[symbol] = reversed
+		c.lengths[symbol] = n
+	}
+	return c
+}
+
+// writePrefixCode writes the Huffman code that fits the histogram best and returns it
+func writePrefixCode(bw *bitWriter, hist []uint32, maxLength int) *prefixCode {
+	var symbols []int
+	for symbol, count := range hist {
+		if count > 0 {
+			symbols = append(symbols, symbol)
+		}
+	}
+
+	// simple code with a single symbol (zero bits per symbol)
+	if len(symbols) <= 1 && (len(symbols) == 0 || symbols[0] < 256) {
+		symbol := 0
+		if len(symbols) == 1 {
+			symbol = symbols[0]
+		}
+		bw.write(1, 1) // simple code
+		bw.write(0, 1) // 1 symbol
+		if symbol < 2 {
+			bw.write(0, 1)
+			bw.write(uint32(symbol), 1)
+		} else {
+			bw.write(1, 1)
+			bw.write(uint32(symbol), 8)
+		}
+		return newPrefixCode(make([]uint8, len(hist)))
+	}
+
+	lengths := huffmanLengths(hist, maxLength)
+	code := newPrefixCode(lengths)
+
+	// run length encode the code lengths
+	type clSymbol struct {
+		symbol    int
+		extra     uint32
+		extraBits uint
+	}
+	var seq []clSymbol
+	for i := 0; i < len(lengths); {
+		n := lengths[i]
+		run := 1
+		for i+run < len(lengths) && lengths[i+run] == n {
+			run++
+		}
+		i += run
+
+		if n == 0 {
+			for run >= 11 {
+				r := min(run, 138)
+				seq = append(seq, clSymbol{18, uint32(r - 11), 7})
+				run -= r
+			}
+			if run >= 3 {
+				seq = append(seq, clSymbol{17, uint32(run - 3), 3})
+				run = 0
+			}
+		} else {
+			seq = append(seq, clSymbol{int(n), 0, 0})
+			run--
+			for run >= 3 {
+				r := min(run, 6)
+				seq = append(seq, clSymbol{16, uint32(r - 3), 2})
+				run -= r
+			}
+		}
+		for ; run > 0; run-- {
+			seq = append(seq, clSymbol{int(n), 0, 0})
+		}
+	}
+
+	clHist := make([]uint32, len(codeLengthCodeOrder))
+	for _, s := range seq {
+		clHist[s.symbol]++
+	}
+	clLengths := huffmanLengths(clHist, 7)
+	clCode := newPrefixCode(clLengths)
+
+	numCodes := len(codeLengthCodeOrder)
+	for numCodes > 4 && clLengths[codeLengthCodeOrder[numCodes-1]] == 0 {
+		numCodes--
+	}
+
+	bw.write(0, 1) // normal code
+	bw.write(uint32(numCodes-4), 4)
+	for _, symbol := range codeLengthCodeOrder[:numCodes] {
+		bw.write(uint32(clLengths[symbol]), 3)
+	}
+	bw.write(0, 1) // code lengths for all symbols follow
+	for _, s := range seq {
+		clCode.write(bw, s.symbol)
+		if s.extraBits > 0 {
+			bw.write(s.extra, s.extraBits)
+		}
+	}
+
+	return code
+}
+
+var codeLengthCodeOrder = [...]int{17, 18, 0, 1, 2, 3, 4, 5, 16, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+
+// huffmanLengths returns the Huffman code lengths of the symbols limited to maxLength bits.
+// If the optimal code is too deep, rare symbols are made more frequent until it fits.
+func huffmanLengths(hist []uint32, maxLength int) []uint8 {
+	lengths := make([]uint8, len(hist))
+
+	var used []int
+	for symbol, count := range hist {
+		if count > 0 {
+			used = append(used, symbol)
+		}
+	}
+	if len(used) == 1 {
+		lengths[used[0]] = 1
+		return lengths
+	}
+
+	for minCount := uint32(1); ; minCount *= 2 {
+		h := make(huffmanHeap, 0, len(used))
+		parents := make([]int, 0, 2*len(used))
+		for _, symbol := range used {
+			count := hist[symbol]
+			if count < minCount {
+				count = minCount
+			}
+			h = append(h, huffmanNode{weight: uint64(count), index: len(parents)})
+			parents = append(parents, -1)
+		}
+		heap.Init(&h)
+		for h.Len() > 1 {
+			a := heap.Pop(&h).(huffmanNode)
+			b := heap.Pop(&h).(huffmanNode)
+			index := len(parents)
+			parents = append(parents, -1)
+			parents[a.index] = index
+			parents[b.index] = index
+			heap.Push(&h, huffmanNode{weight: a.weight + b.weight, index: index})
+		}
+
+		fits := true
+		for i, symbol := range used {
+			depth := 0
+			for p := parents[i]; p != -1; p = parents[p] {
+				depth++
+			}
+			if depth > maxLength {
+				fits = false
+				break
+			}
+			lengths[symbol] = uint8(depth)
+		}
+		if fits {
+			return lengths
+		}
+	}
+}
+
+type huffmanNode struct {
+	weight uint64
+	index  int
+}
+
+type huffmanHeap []huffmanNode
+
+func (h huffmanHeap) Len() int { return len(h) }
+func (h huffmanHeap) Less(i, j int) bool {
+	if h[i].weight == h[j].weight {
+		return h[i].index < h[j].index
+	}
+	return h[i].weight < h[j].weight
+}
+func (h huffmanHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *huffmanHeap) Push(x interface{}) { *h = append(*h, x.(huffmanNode)) }
+func (h *huffmanHeap) Pop() interface{} {
+	old := *h
+	n := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return n
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,108 @@
+package thumb
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"math/rand"
+	"testing"
+
+	"golang.org/x/image/webp"
+)
+
+func TestEncodeWebPRoundTrip(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	tests := []struct {
+		name          string
+		width, height int
+		pixel         func(x, y int) color.NRGBA
+	}{
+		{"single pixel", 1, 1, func(x, y int) color.NRGBA {
+			return color.NRGBA{0x12, 0x34, 0x56, 0xff}
+		}},
+		{"gradient", 67, 33, func(x, y int) color.NRGBA {
+			return color.NRGBA{uint8(x * 3), uint8(y * 7), uint8(x + y), 0xff}
+		}},
+		{"transparent", 40, 25, func(x, y int) color.NRGBA {
+			return color.NRGBA{uint8(x), 0x80, uint8(y), uint8(x * y)}
+		}},
+		{"flat areas", 300, 20, func(x, y int) color.NRGBA {
+			if x < 150 {
+				return color.NRGBA{0xff, 0xff, 0xff, 0xff}
+			}
+			return color.NRGBA{0x20, 0x40, uint8(y / 5), 0xff}
+		}},
+		{"stripes", 50, 50, func(x, y int) color.NRGBA {
+			if y%2 == 0 {
+				return color.NRGBA{0, 0, 0, 0xff}
+			}
+			return color.NRGBA{uint8(x), 0xff, 0, 0x7f}
+		}},
+		{"noise", 61, 47, func(x, y int) color.NRGBA {
+			return color.NRGBA{uint8(rnd.Intn(256)), uint8(rnd.Intn(256)), uint8(rnd.Intn(256)), uint8(rnd.Intn(256))}
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src := image.NewNRGBA(image.Rect(0, 0, tt.width, tt.height))
+			for y := 0; y < tt.height; y++ {
+				for x := 0; x < tt.width; x++ {
+					src.SetNRGBA(x, y, tt.pixel(x, y))
+				}
+			}
+
+			var buf bytes.Buffer
+			if err := encodeWebP(&buf, src); err != nil {
+				t.Fatal(err)
+			}
+			decoded, err := webp.Decode(&buf)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if decoded.Bounds() != src.Bounds() {
+				t.Fatalf("bounds = %v, want %v", decoded.Bounds(), src.Bounds())
+			}
+
+			for y := 0; y < tt.height; y++ {
+				for x := 0; x < tt.width; x++ {
+					want := src.NRGBAAt(x, y)
+					if want.A == 0 {
+						want = color.NRGBA{} // the color of fully transparent pixels doesn't matter
+					}
+					got := color.NRGBAModel.Convert(decoded.At(x, y)).(color.NRGBA)
+					if got.A == 0 {
+						got = color.NRGBA{}
+					}
+					if got != want {
+						t.Fatalf("pixel at %d,%d = %v, want %v", x, y, got, want)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestEncodeWebPSubImage(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 20, 20))
+	for i := range src.Pix {
+		src.Pix[i] = 0xff
+	}
+	src.Set(10, 10, color.RGBA{0xff, 0, 0, 0xff})
+	sub := src.SubImage(image.Rect(5, 5, 15, 15))
+
+	var buf bytes.Buffer
+	if err := encodeWebP(&buf, sub); err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := webp.Decode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := decoded.Bounds(); b.Dx() != 10 || b.Dy() != 10 {
+		t.Fatalf("unexpected bounds: %v", b)
+	}
+	if got := color.NRGBAModel.Convert(decoded.At(5, 5)).(color.NRGBA); got != (color.NRGBA{0xff, 0, 0, 0xff}) {
+		t.Fatalf("pixel at 5,5 = %v, want red", got)
+	}
+}